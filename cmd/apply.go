@@ -0,0 +1,98 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eskersoftware/coolknative/pkg/stack"
+	"github.com/spf13/cobra"
+)
+
+func MakeApply() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "apply",
+		Short: "Install the components declared in a stack file",
+		Long: `Install the components declared in a stack file, in dependency order.
+Each component is installed with its "coolknative install" command, its
+settings are passed as flags to this command.`,
+		Example: `  coolknative apply -f stack.yaml
+  coolknative apply -f stack.yaml --kubeconfig ./kubeconfig --context staging
+  coolknative apply -f stack.yaml --dry-run --output-dir ./rendered
+  coolknative apply -f stack.yaml --bundle coolknative-bundle-0.1.0.tgz`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().StringP("file", "f", "", "Stack file to apply")
+	command.Flags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.Flags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+	command.Flags().Bool("dry-run", false, "Render the manifests of every component without touching the cluster")
+	command.Flags().String("output-dir", "", "With --dry-run, write the manifests to COMPONENT.yaml, chart-RELEASE.yaml for charts, in this directory instead of stdout")
+	command.Flags().String("bundle", "", "Read the manifests, charts and helm from a bundle instead of the network")
+	command.Flags().String("lock-file", "coolknative.lock", "Pin the downloaded manifests and charts in this file, and fail when they change upstream, empty to disable")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		filename, _ := command.Flags().GetString("file")
+		if len(filename) == 0 {
+			return fmt.Errorf("give a stack file with --file")
+		}
+
+		s, err := stack.Load(filename)
+		if err != nil {
+			return err
+		}
+
+		components, err := s.Ordered()
+		if err != nil {
+			return err
+		}
+
+		globalFlags := []string{}
+		if command.Flags().Changed("kubeconfig") {
+			kubeconfig, _ := command.Flags().GetString("kubeconfig")
+			globalFlags = append(globalFlags, "--kubeconfig="+kubeconfig)
+		}
+		if kubeContext, _ := command.Flags().GetString("context"); len(kubeContext) > 0 {
+			globalFlags = append(globalFlags, "--context="+kubeContext)
+		}
+		dryRun, _ := command.Flags().GetBool("dry-run")
+		if dryRun {
+			globalFlags = append(globalFlags, "--dry-run")
+		}
+		if outputDir, _ := command.Flags().GetString("output-dir"); len(outputDir) > 0 {
+			globalFlags = append(globalFlags, "--output-dir="+outputDir)
+		}
+		if bundle, _ := command.Flags().GetString("bundle"); len(bundle) > 0 {
+			globalFlags = append(globalFlags, "--bundle="+bundle)
+		}
+		lockFile, _ := command.Flags().GetString("lock-file")
+		globalFlags = append(globalFlags, "--lock-file="+lockFile)
+
+		for _, component := range components {
+			flags, err := component.Flags()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Applying component %s\n", component.Key())
+
+			install := MakeInstall()
+			install.SilenceErrors = true
+			install.SetArgs(append(append([]string{component.Name}, flags...), globalFlags...))
+			if err := install.Execute(); err != nil {
+				return fmt.Errorf("unable to install component %s: %s", component.Key(), err)
+			}
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		fmt.Printf("Stack %s has been applied.\n", s.Metadata.Name)
+
+		return nil
+	}
+
+	return command
+}
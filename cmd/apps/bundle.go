@@ -0,0 +1,192 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/bundle"
+	"github.com/eskersoftware/coolknative/pkg/helm"
+	"github.com/eskersoftware/coolknative/pkg/versions"
+)
+
+// bundlePath is set from the --bundle flag by useDefaultKubeconfig, the
+// installers then read their artifacts from this bundle instead of the network.
+var bundlePath string
+
+// installBundle is opened on first use.
+var installBundle *bundle.Bundle
+
+const redisChartRepo = "https://charts.bitnami.com/bitnami"
+
+// downloadTimeout bounds each download of a manifest, so that a stalled
+// server or mirror fails the install instead of hanging it.
+const downloadTimeout = 2 * time.Minute
+
+var httpClient = &http.Client{Timeout: downloadTimeout}
+
+// getBundle returns the bundle given with --bundle, or nil when the artifacts
+// are to be downloaded.
+func getBundle() (*bundle.Bundle, error) {
+	if len(bundlePath) == 0 {
+		return nil, nil
+	}
+	if installBundle == nil {
+		b, err := bundle.Open(bundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open bundle %s: %s", bundlePath, err)
+		}
+		installBundle = b
+	}
+	return installBundle, nil
+}
+
+func kustomizationKey(target string) string {
+	return "kustomize:" + target
+}
+
+func chartKey(repo, chart, version string) string {
+	key := "chart:" + chart
+	if len(repo) > 0 {
+		key = "chart:" + repo + "/" + chart
+	}
+	if len(version) > 0 {
+		key += "@" + version
+	}
+	return key
+}
+
+// releaseManifests gives the manifests of each component of the
+// compatibility matrix.
+var releaseManifests = map[string]func(versions.Release) []string{
+	"cert-manager":            certManagerManifests,
+	"knative-eventing":        knativeEventingManifests,
+	"knative-serving":         knativeServingBundleManifests,
+	"nats-operator":           natsOperatorManifests,
+	"nats-streaming-operator": natsStreamingOperatorManifests,
+	"tekton":                  tektonManifests,
+}
+
+// CreateBundle downloads every artifact the installers fetch from the
+// network, for the default versions or the ones given by component name, like
+// the --version flag of their installer.
+func CreateBundle(version string, selected map[string]string) (*bundle.Bundle, error) {
+	b := bundle.New(version)
+
+	releases := map[string]string{}
+	for _, c := range versions.Components() {
+		release, err := c.Release(selected[c.Name], false)
+		if err != nil {
+			return nil, err
+		}
+		releases[c.Name] = release.Version
+
+		manifests, ok := releaseManifests[c.Name]
+		if !ok {
+			continue
+		}
+		for _, u := range manifests(release) {
+			fmt.Fprintf(messages, "Downloading %s\n", u)
+			data, err := download(u)
+			if err != nil {
+				return nil, err
+			}
+			b.Add(u, data)
+		}
+	}
+
+	minioOperator := minioOperatorKustomization(releases["minio-operator"])
+	fmt.Fprintf(messages, "Building kustomization %s\n", minioOperator)
+	manifest, err := buildKustomization(minioOperator, releases["minio-operator"])
+	if err != nil {
+		return nil, err
+	}
+	b.Add(kustomizationKey(minioOperator), manifest)
+
+	fmt.Fprintf(messages, "Downloading chart redis from %s\n", redisChartRepo)
+	chart, err := downloadChart(redisChartRepo, "redis", releases["redis"])
+	if err != nil {
+		return nil, err
+	}
+	b.Add(chartKey(redisChartRepo, "redis", releases["redis"]), chart)
+
+	return b, nil
+}
+
+func download(u string) ([]byte, error) {
+	res, err := httpClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", u, res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// downloadChart downloads the archive of a chart from its repository with the
+// helm client, checked against the digest of the index like on install, the
+// latest version when version is empty.
+func downloadChart(repo, chart, version string) ([]byte, error) {
+	client, err := getHelmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "bundle-charts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	filename, err := client.LocateChart(chart, repo, version, dir)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// extractBundledChart unpacks the archive of a bundled chart in dir and
+// returns the path of the chart directory, which helm accepts in place of a
+// chart reference.
+func extractBundledChart(b *bundle.Bundle, dir, repo, chart, version string) (string, error) {
+	data, err := b.Get(chartKey(repo, chart, version))
+	if err != nil {
+		return "", err
+	}
+
+	// Files of another version must not be mixed in
+	target := path.Join(dir, "bundled", path.Base(chart))
+	err = os.RemoveAll(target)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(target, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	err = helm.Extract(bytes.NewReader(data), target, helm.ExtractOptions{KeepDirs: true})
+	if err != nil {
+		return "", fmt.Errorf("unable to extract bundled chart %s: %s", chart, err)
+	}
+
+	// A chart archive holds the directory of the chart
+	entries, err := ioutil.ReadDir(target)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return "", fmt.Errorf("bundled chart %s is not a chart archive", chart)
+	}
+	return path.Join(target, entries[0].Name()), nil
+}
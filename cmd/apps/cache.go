@@ -0,0 +1,32 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"path"
+
+	"github.com/eskersoftware/coolknative/pkg/cache"
+	"github.com/eskersoftware/coolknative/pkg/config"
+)
+
+// lockFile is set from the --lock-file flag by useDefaultKubeconfig, the
+// downloaded artifacts are pinned in it.
+var lockFile string
+
+// artifactCache is opened on first use, in the user dir.
+var artifactCache *cache.Cache
+
+func getCache() (*cache.Cache, error) {
+	if artifactCache == nil {
+		userPath, err := config.InitUserDir()
+		if err != nil {
+			return nil, err
+		}
+		c, err := cache.Open(path.Join(userPath, "cache"), lockFile)
+		if err != nil {
+			return nil, err
+		}
+		artifactCache = c
+	}
+	return artifactCache, nil
+}
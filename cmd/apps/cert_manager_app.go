@@ -0,0 +1,114 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"fmt"
+
+	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/versions"
+	"github.com/spf13/cobra"
+)
+
+func MakeInstallCertManager() *cobra.Command {
+	var certManager = &cobra.Command{
+		Use:   "cert-manager",
+		Short: "Install cert-manager",
+		Long: `Install cert-manager, which issues and renews the certificates of
+"install knative-serving --tls-issuer".`,
+		Example:      `  coolknative install cert-manager`,
+		SilenceUsage: true,
+	}
+
+	addVersionFlags(certManager, "cert-manager")
+
+	certManager.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		release, err := getRelease(command, "cert-manager")
+		if err != nil {
+			return err
+		}
+
+		err = applyManifests("", release.Version, certManagerManifests(release))
+		if err != nil {
+			return err
+		}
+
+		err = recordVersion("cert-manager", release.Version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, CertManagerInstallMsg)
+
+		return nil
+	}
+
+	return certManager
+}
+
+func MakeUninstallCertManager() *cobra.Command {
+	var certManager = &cobra.Command{
+		Use:          "cert-manager",
+		Short:        "Uninstall cert-manager",
+		Long:         `Uninstall cert-manager, the issued certificates are kept in their secrets`,
+		Example:      `  coolknative uninstall cert-manager`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(certManager, "cert-manager")
+
+	certManager.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "cert-manager")
+		if err != nil {
+			return err
+		}
+
+		err = deleteManifests("", release.Version, certManagerManifests(release), keepCRDs)
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion("cert-manager")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Cert-manager has been uninstalled.")
+
+		return nil
+	}
+
+	return certManager
+}
+
+func certManagerManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/jetstack/cert-manager/releases/download/%s/cert-manager.yaml", release.Version),
+	}
+}
+
+var certManagerReadiness = componentHealth{
+	Name: "cert-manager",
+	Checks: []readinessCheck{
+		deploymentAvailable("cert-manager", "cert-manager"),
+		deploymentAvailable("cert-manager", "cert-manager-cainjector"),
+		deploymentAvailable("cert-manager", "cert-manager-webhook"),
+	},
+	VersionLabel: "app.kubernetes.io/version",
+}
+
+const CertManagerInfoMsg = `
+# Issue a wildcard certificate for knative-serving with:
+# coolknative install knative-serving --domain mydomain.com --tls-issuer selfsigned
+`
+
+const CertManagerInstallMsg = `
+=======================================================================
+= Cert-manager has been installed.                                    =
+=======================================================================` +
+	"\n\n" + CertManagerInfoMsg + "\n\n" + pkg.ThanksForUsing
@@ -0,0 +1,153 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// chartPrefix prefixes the charts installed with "install chart" in the
+// versions ConfigMap, which gives their release and namespace to the status
+// and wait-install commands.
+const chartPrefix = "chart."
+
+func MakeInstallChart() *cobra.Command {
+	var chart = &cobra.Command{
+		Use:   "chart",
+		Short: "Install a helm chart",
+		Long: `Install or upgrade a release of any helm chart. The release is recorded as
+installed, so "status" and "install wait-install" check it like the other apps.`,
+		Example: `  coolknative install chart --release-name ingress --namespace ingress-nginx \
+    --repo https://kubernetes.github.io/ingress-nginx --chart ingress-nginx --version 3.7.1
+  coolknative install chart --release-name exporter --chart ./exporter-1.0.0.tgz --values exporter.yaml
+  coolknative install chart --release-name exporter --chart oci://registry.corp.local/charts/exporter --wait`,
+		SilenceUsage: true,
+	}
+
+	chart.Flags().String("release-name", "", "Name of the helm release")
+	chart.Flags().String("namespace", "default", "Kubernetes namespace for the release")
+	chart.Flags().String("version", defaultVersion, "Version of the chart, the latest when empty")
+	addChartFlags(chart, "", "")
+	addHelmValueFlags(chart)
+
+	chart.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		releaseName, _ := chart.Flags().GetString("release-name")
+		ns, _ := chart.Flags().GetString("namespace")
+		version, _ := chart.Flags().GetString("version")
+		wait, _ := command.Flags().GetBool("wait")
+
+		if len(releaseName) == 0 {
+			return fmt.Errorf("give the name of the release with --release-name")
+		}
+		if name, _ := chart.Flags().GetString("chart"); len(name) == 0 {
+			return fmt.Errorf("give the chart to install with --chart")
+		}
+
+		path, err := locateChart(command, version)
+		if err != nil {
+			return err
+		}
+
+		values := getHelmValues(command)
+		resetValues, _ := chart.Flags().GetBool("reset-values")
+
+		err = createNamespace(ns)
+		if err != nil {
+			return err
+		}
+
+		if isDryRun() {
+			manifest, err := templateChart(path, releaseName, ns, values)
+			if err != nil {
+				return fmt.Errorf("unable to template chart with helm %s", err)
+			}
+			return renderer.Apply(context.Background(), manifest, ns)
+		}
+
+		err = helm3Upgrade(path, releaseName, ns, values, resetValues, wait)
+		if err != nil {
+			return fmt.Errorf("unable to install chart with helm %s", err)
+		}
+
+		err = recordVersion(chartComponent(ns, releaseName), version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(messages, "Release %s has been installed in %s, check it with \"coolknative status\".\n", releaseName, ns)
+		return nil
+	}
+
+	return chart
+}
+
+func MakeUninstallChart() *cobra.Command {
+	var chart = &cobra.Command{
+		Use:          "chart",
+		Short:        "Uninstall a helm chart",
+		Long:         `Uninstall a release installed with "install chart"`,
+		Example:      `  coolknative uninstall chart --release-name ingress --namespace ingress-nginx`,
+		SilenceUsage: true,
+	}
+
+	chart.Flags().String("release-name", "", "Name of the helm release")
+	chart.Flags().String("namespace", "default", "Kubernetes namespace of the release")
+
+	chart.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		releaseName, _ := chart.Flags().GetString("release-name")
+		ns, _ := chart.Flags().GetString("namespace")
+
+		if len(releaseName) == 0 {
+			return fmt.Errorf("give the name of the release with --release-name")
+		}
+
+		err := helm3Uninstall(releaseName, ns)
+		if err != nil {
+			return fmt.Errorf("unable to uninstall chart with helm %s", err)
+		}
+
+		err = forgetVersion(chartComponent(ns, releaseName))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(messages, "Release %s has been uninstalled from %s.\n", releaseName, ns)
+		return nil
+	}
+
+	return chart
+}
+
+// chartComponent names the component of a release installed with "install
+// chart", namespaces have no dots so the release name can be split back.
+func chartComponent(namespace, releaseName string) string {
+	return chartPrefix + namespace + "." + releaseName
+}
+
+// chartReadiness checks a release installed with "install chart": its helm
+// revision, and the deployments and stateful sets labelled as part of it by
+// the usual chart conventions.
+func chartReadiness(component string) componentHealth {
+	parts := strings.SplitN(strings.TrimPrefix(component, chartPrefix), ".", 2)
+	namespace, releaseName := parts[0], parts[len(parts)-1]
+
+	deployments := deploymentsAvailable(namespace, "app.kubernetes.io/instance="+releaseName, "release="+releaseName)
+	deployments.Optional = true
+	statefulSets := statefulSetsReady(namespace, "app.kubernetes.io/instance="+releaseName, "release="+releaseName)
+	statefulSets.Optional = true
+
+	return componentHealth{
+		Name:          component,
+		Checks:        []readinessCheck{deployments, statefulSets},
+		HelmRelease:   releaseName,
+		HelmNamespace: namespace,
+	}
+}
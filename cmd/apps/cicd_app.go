@@ -3,14 +3,18 @@
 package apps
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
 	"io/ioutil"
 	"log"
 	"strings"
 
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	b64 "encoding/base64"
 )
@@ -26,6 +30,8 @@ type CicdInputData struct {
 	MinioSecretKeyBase64         string
 	TokenWebservice1DataBase64   string
 	TokenWebservice2DataBase64   string
+	RedisPasswordBase64          string
+	GenerateSecrets              bool
 	KnativeServingDomainTemplate string
 	Domain                       string
 	Name                         string
@@ -69,11 +75,6 @@ type TlsInputData struct {
 	TlsKeyDataBase64 string
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
 func MakeInstallCicd() *cobra.Command {
 	var cicd = &cobra.Command{
 		Use:          "cicd",
@@ -95,9 +96,11 @@ func MakeInstallCicd() *cobra.Command {
 	cicd.Flags().StringP("ssh-git-server", "g", "", "ssh git server")
 	cicd.Flags().StringP("knative-serving-domain-template", "t", "{{.Name}}.{{.Namespace}}.{{.Domain}}", "knative serving domain template")
 	cicd.Flags().StringP("domain", "w", "example.com", "knative serving domain name")
+	addDomainModeFlag(cicd)
 	cicd.Flags().StringP("namespace-api", "a", "api-ns", "namespace where the api will be accessible")
 	cicd.Flags().StringP("minio-access-key", "", "minio", "Minio access key")
 	cicd.Flags().StringP("minio-secret-key", "", "minio123", "Minio secret key")
+	addGenerateSecretsFlag(cicd)
 	cicd.Flags().StringP("cool-knative-docker-image", "", "eqqe/coolknative:latest", "Docker image for coolknative exec")
 	cicd.Flags().StringP("public-ip", "", "localhost", "Public ip for dns for domain")
 	cicd.Flags().StringP("apps-git", "", "https://github.com/eskersoftware/example-coolknative-webservices.git", "")
@@ -128,6 +131,7 @@ func MakeInstallCicd() *cobra.Command {
 		publicIp, _ := command.Flags().GetString("public-ip")
 		appsGit, _ := command.Flags().GetString("apps-git")
 		fileResourcesGit, _ := command.Flags().GetString("file-resources-git")
+		generateSecrets, _ := command.Flags().GetBool("generate-secrets")
 		applicationNamespaces, applicationNamespacesError := command.Flags().GetStringArray("add-application-namespace")
 		if applicationNamespacesError != nil {
 			return fmt.Errorf("error with --add-application-namespace usage: %s", applicationNamespacesError)
@@ -148,17 +152,70 @@ func MakeInstallCicd() *cobra.Command {
 
 		useDefaultKubeconfig(command)
 
-		_, nsErr := kubectlTask("create", "namespace", namespace)
+		magicSuffix, err := getMagicDomainSuffix(command)
+		if err != nil {
+			return err
+		}
+		if len(magicSuffix) > 0 {
+			if command.Flags().Changed("domain") {
+				return fmt.Errorf("--domain and --domain-mode cannot be used together")
+			}
+			// The domain of an installed knative-serving is kept, it is only
+			// derived from the IP when knative-serving is installed by cicd
+			domain, err = installedDefaultDomain()
+			if err != nil {
+				return err
+			}
+			switch {
+			case len(domain) > 0:
+				if !strings.HasSuffix(domain, "."+magicSuffix) {
+					return fmt.Errorf("knative-serving serves %s, which is not a domain of --domain-mode, give it with --domain", domain)
+				}
+				if publicIp == "localhost" {
+					publicIp = strings.TrimSuffix(domain, "."+magicSuffix)
+				}
+			case publicIp == "localhost":
+				return fmt.Errorf("--domain-mode requires --public-ip when knative-serving is not installed yet")
+			default:
+				domain, err = magicDomain(publicIp, magicSuffix)
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(messages, "Using domain %s\n", domain)
+		}
+
+		nsErr := createNamespace(namespace)
 		if nsErr != nil {
 			return nsErr
 		}
 
+		tokenWebservice1 := "dev_token"
+		tokenWebservice2 := "dev_token"
+		redisPassword := ""
+		if generateSecrets {
+			secrets, err := getGeneratedSecrets()
+			if err != nil {
+				return err
+			}
+			// Credentials given explicitly take precedence
+			if !command.Flags().Changed("minio-access-key") {
+				minioAccessKey = secrets[minioAccessKeySecret]
+			}
+			if !command.Flags().Changed("minio-secret-key") {
+				minioSecretKey = secrets[minioSecretKeySecret]
+			}
+			tokenWebservice1 = secrets[tokenWebservice1Secret]
+			tokenWebservice2 = secrets[tokenWebservice2Secret]
+			redisPassword = secrets[redisPasswordSecret]
+		}
+
 		inputData := CicdSaInputData{
 			Namespace:    namespace,
 			NamespaceApi: namespaceApi,
 		}
 
-		err := buildApplyYAML(inputData, cicdNamespaceServiceAccountYamlTemplate, "temp_cicd_sa.yaml")
+		err = buildApplyYAML(inputData, cicdNamespaceServiceAccountYamlTemplate, "temp_cicd_sa.yaml")
 		if err != nil {
 			return err
 		}
@@ -181,15 +238,20 @@ func MakeInstallCicd() *cobra.Command {
 		dockerPasswordBase64 := b64.URLEncoding.EncodeToString([]byte(dockerPassword))
 		minioAccessKeyBase64 := b64.URLEncoding.EncodeToString([]byte(minioAccessKey))
 		minioSecretKeyBase64 := b64.URLEncoding.EncodeToString([]byte(minioSecretKey))
-		tokenWebservice1DataBase64 := b64.URLEncoding.EncodeToString([]byte("dev_token"))
-		tokenWebservice2DataBase64 := b64.URLEncoding.EncodeToString([]byte("dev_token"))
+		tokenWebservice1DataBase64 := b64.URLEncoding.EncodeToString([]byte(tokenWebservice1))
+		tokenWebservice2DataBase64 := b64.URLEncoding.EncodeToString([]byte(tokenWebservice2))
+		redisPasswordBase64 := b64.URLEncoding.EncodeToString([]byte(redisPassword))
 		if tokenWebservice1Filename != "" {
 			err, tokenWebservice1DataBase64 = FileToBase64(tokenWebservice1Filename)
-			check(err)
+			if err != nil {
+				return err
+			}
 		}
 		if tokenWebservice2Filename != "" {
 			err, tokenWebservice2DataBase64 = FileToBase64(tokenWebservice2Filename)
-			check(err)
+			if err != nil {
+				return err
+			}
 		}
 		inputData3 := CicdInputData{
 			DockerServer:                 dockerServer,
@@ -202,6 +264,8 @@ func MakeInstallCicd() *cobra.Command {
 			MinioSecretKeyBase64:         minioSecretKeyBase64,
 			TokenWebservice1DataBase64:   tokenWebservice1DataBase64,
 			TokenWebservice2DataBase64:   tokenWebservice2DataBase64,
+			RedisPasswordBase64:          redisPasswordBase64,
+			GenerateSecrets:              generateSecrets,
 			KnativeServingDomainTemplate: knativeServingDomainTemplate,
 			Domain:                       domain,
 			NamespaceApi:                 namespaceApi,
@@ -227,7 +291,9 @@ func MakeInstallCicd() *cobra.Command {
 
 		if sshGitServer != "" && sshPrivateKeyFilename != "" {
 			err, sshPrivateKeyDataBase64 := FileToBase64(sshPrivateKeyFilename)
-			check(err)
+			if err != nil {
+				return err
+			}
 			inputData4 := SshGitInputData{
 				Namespace:               namespace,
 				SshGitServer:            sshGitServer,
@@ -241,9 +307,13 @@ func MakeInstallCicd() *cobra.Command {
 
 		if tlsCrtFilename != "" && tlsKeyFilename != "" {
 			err, tlsCrtDataBase64 := FileToBase64(tlsCrtFilename)
-			check(err)
+			if err != nil {
+				return err
+			}
 			err, tlsKeyDataBase64 := FileToBase64(tlsKeyFilename)
-			check(err)
+			if err != nil {
+				return err
+			}
 			inputData := TlsInputData{
 				TlsCrtDataBase64: tlsCrtDataBase64,
 				TlsKeyDataBase64: tlsKeyDataBase64,
@@ -264,7 +334,73 @@ func MakeInstallCicd() *cobra.Command {
 			return err
 		}
 
-		fmt.Println(CicdInstallMsg)
+		fmt.Fprintln(messages, CicdInstallMsg)
+
+		return nil
+	}
+
+	return cicd
+}
+
+func MakeUninstallCicd() *cobra.Command {
+	var cicd = &cobra.Command{
+		Use:          "cicd",
+		Short:        "Uninstall cicd",
+		Long:         `Uninstall the cicd pipelines, secrets, ClusterRoleBinding and application namespaces.`,
+		Example:      `  coolknative uninstall cicd --add-application-namespace-knative-injection namespace1`,
+		SilenceUsage: true,
+	}
+
+	cicd.Flags().StringP("namespace", "n", "cicd", "Cicd install namespace")
+	cicd.Flags().StringP("namespace-api", "a", "api-ns", "namespace where the api will be accessible")
+	cicd.Flags().StringArrayP("add-application-namespace", "", []string{}, "Application namespace to delete")
+	cicd.Flags().StringArrayP("add-application-namespace-knative-injection", "i", []string{}, "Application namespace with knative injection to delete")
+
+	cicd.RunE = func(command *cobra.Command, args []string) error {
+		namespace, _ := command.Flags().GetString("namespace")
+		namespaceApi, _ := command.Flags().GetString("namespace-api")
+		applicationNamespaces, _ := command.Flags().GetStringArray("add-application-namespace")
+		applicationNamespacesKnativeInjectionEnabled, _ := command.Flags().GetStringArray("add-application-namespace-knative-injection")
+
+		useDefaultKubeconfig(command)
+
+		client, err := getKubeClient()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range append(applicationNamespaces, applicationNamespacesKnativeInjectionEnabled...) {
+			err = client.DeleteObject(context.Background(), k8s.Object{APIVersion: "v1", Kind: "Namespace", Name: v})
+			if err != nil {
+				return err
+			}
+		}
+
+		inputData := CicdInputData{
+			Namespace:    namespace,
+			NamespaceApi: namespaceApi,
+		}
+		err = buildDeleteYAML(inputData, cicdYamlTemplate)
+		if err != nil {
+			return err
+		}
+
+		err = client.DeleteObject(context.Background(), k8s.Object{APIVersion: "v1", Kind: "Secret", Namespace: "knative-serving", Name: "tls"})
+		if err != nil {
+			return err
+		}
+
+		err = deleteCicdClusterRoleBindings(client, namespace)
+		if err != nil {
+			return err
+		}
+
+		err = client.DeleteObject(context.Background(), k8s.Object{APIVersion: "v1", Kind: "Namespace", Name: namespace})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Cicd has been uninstalled.")
 
 		return nil
 	}
@@ -272,12 +408,48 @@ func MakeInstallCicd() *cobra.Command {
 	return cicd
 }
 
+// deleteCicdClusterRoleBindings removes the bindings created from
+// cicdClusterRoleBindingYamlTemplate, which only have a generated name.
+func deleteCicdClusterRoleBindings(client k8s.Client, namespace string) error {
+	bindings, err := client.List(context.Background(), "rbac.authorization.k8s.io/v1", "ClusterRoleBinding", "", metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, binding := range bindings {
+		if binding.GetGenerateName() != "default-cluster-admin-" {
+			continue
+		}
+		subjects, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+		for _, s := range subjects {
+			subject, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if subject["kind"] == "ServiceAccount" && subject["name"] == "default" && subject["namespace"] == namespace {
+				err = client.DeleteObject(context.Background(), k8s.Object{
+					APIVersion: "rbac.authorization.k8s.io/v1",
+					Kind:       "ClusterRoleBinding",
+					Name:       binding.GetName(),
+				})
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
 func FileToBase64(filename string) (error, string) {
 	data, err := ioutil.ReadFile(filename)
-	check(err)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", filename, err), ""
+	}
 	data = []byte(strings.Replace(string(data), "\r\n", "\n", -1))
 	dataBase64 := b64.URLEncoding.EncodeToString(data)
-	return err, dataBase64
+	return nil, dataBase64
 }
 
 func createPipeline(inputData CicdSaInputData, applicationListWithNamespace []string, beginPipelineTemplateYaml string) error {
@@ -314,22 +486,16 @@ func createPipeline(inputData CicdSaInputData, applicationListWithNamespace []st
 	}
 	yamlApplicationsSkaffold = append(yamlApplicationsSkaffold, endSkaffoldApplicationListYaml...)
 
-	tempFile, tempFileErr := writeTempFile(yamlApplicationsSkaffold, "temp_applications_skaffold.yaml")
-	if tempFileErr != nil {
-		log.Print("Unable to save generated yaml file into the temporary directory")
-		return tempFileErr
+	client, err := getKubeClient()
+	if err != nil {
+		return err
 	}
 
-	res, err := kubectlTask("apply", "-f", tempFile)
-
+	err = client.Apply(context.Background(), yamlApplicationsSkaffold, "")
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-
-	if res.ExitCode != 0 {
-		return fmt.Errorf(res.Stderr)
-	}
 	return nil
 }
 
@@ -471,8 +637,12 @@ spec:
     - redis
     - --namespace
     - redis
+{{- if .GenerateSecrets}}
+    - --generate-secrets
+{{- else}}
     - --set
     - usePassword=false
+{{- end}}
   - name: install-infra-step-coolknative-knative-serving
     args:
     - install
@@ -759,6 +929,11 @@ spec:
         secretKeyRef:
           name: minio
           key: secretkey
+    - name: REDIS_PASSWORD
+      valueFrom:
+        secretKeyRef:
+          name: redis
+          key: password
     workingDir: /workspace/workspace/test_qa_prod
     command:
     - /bin/bash
@@ -894,6 +1069,24 @@ metadata:
 type: Opaque
 ---
 apiVersion: v1
+data:
+  password: "{{.RedisPasswordBase64}}"
+kind: Secret
+metadata:
+  name: redis
+  namespace: {{.Namespace}}
+type: Opaque
+---
+apiVersion: v1
+data:
+  password: "{{.RedisPasswordBase64}}"
+kind: Secret
+metadata:
+  name: redis
+  namespace: {{.NamespaceApi}}
+type: Opaque
+---
+apiVersion: v1
 kind: ConfigMap
 metadata:
   name: domain-config
@@ -0,0 +1,287 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultPullSecret is the registry secret created by the cicd installer in
+// the namespaces of the applications.
+const defaultPullSecret = "regcred"
+
+// DefaultDeployTimeout bounds the wait for a deployed service, which includes
+// pulling its image.
+const DefaultDeployTimeout = 5 * time.Minute
+
+// DeploySpec describes a Knative Service, read from the file of "deploy -f"
+// and overridden by the flags.
+type DeploySpec struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Image     string `json:"image"`
+	// Env gives the value of environment variables.
+	Env map[string]string `json:"env,omitempty"`
+	// SecretEnv and ConfigMapEnv read environment variables from keys of
+	// Secrets and ConfigMaps, written NAME:KEY.
+	SecretEnv    map[string]string `json:"secretEnv,omitempty"`
+	ConfigMapEnv map[string]string `json:"configMapEnv,omitempty"`
+	// CicdEnv adds the credentials and the domain settings created by the
+	// cicd installer.
+	CicdEnv      bool   `json:"cicdEnv,omitempty"`
+	MinScale     *int   `json:"minScale,omitempty"`
+	MaxScale     *int   `json:"maxScale,omitempty"`
+	Concurrency  *int   `json:"concurrency,omitempty"`
+	ClusterLocal bool   `json:"clusterLocal,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	H2C          bool   `json:"h2c,omitempty"`
+	PullSecret   string `json:"pullSecret,omitempty"`
+}
+
+// cicdSecretEnv and cicdConfigMapEnv are the environment variables given by
+// the cicd installer to the webservices it deploys.
+var cicdSecretEnv = map[string]string{
+	"MINIO_ACCESS_KEY":   "minio:accesskey",
+	"MINIO_SECRET_KEY":   "minio:secretkey",
+	"REDIS_PASSWORD":     "redis:password",
+	"TOKEN_WEBSERVICE_1": "token:token-webservice-1",
+	"TOKEN_WEBSERVICE_2": "token:token-webservice-2",
+}
+
+var cicdConfigMapEnv = map[string]string{
+	"NAMESPACE":                       "domain-config:namespace",
+	"DOMAIN":                          "domain-config:domain",
+	"PUBLIC_IP":                       "domain-config:public_ip",
+	"KNATIVE_SERVING_DOMAIN_TEMPLATE": "domain-config:knative_serving_domain_template",
+}
+
+// ReadDeploySpec reads the spec of a Knative Service from a YAML file.
+func ReadDeploySpec(filename string) (DeploySpec, error) {
+	spec := DeploySpec{}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return spec, err
+	}
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return spec, fmt.Errorf("unable to read the spec of %s: %s", filename, err)
+	}
+	return spec, nil
+}
+
+// ParseEnv adds NAME=VALUE assignments to env.
+func ParseEnv(env map[string]string, assignments []string, flag string) (map[string]string, error) {
+	if env == nil {
+		env = map[string]string{}
+	}
+	for _, assignment := range assignments {
+		i := strings.Index(assignment, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("--%s takes NAME=VALUE, got %q", flag, assignment)
+		}
+		env[assignment[:i]] = assignment[i+1:]
+	}
+	return env, nil
+}
+
+// buildKnativeService returns the manifest of the Knative Service of a spec.
+func buildKnativeService(spec DeploySpec) ([]byte, error) {
+	if len(spec.Name) == 0 || len(spec.Image) == 0 {
+		return nil, fmt.Errorf("deploy requires a name and an --image")
+	}
+	if spec.MinScale != nil && spec.MaxScale != nil && *spec.MaxScale > 0 && *spec.MinScale > *spec.MaxScale {
+		return nil, fmt.Errorf("--min-scale %d is greater than --max-scale %d", *spec.MinScale, *spec.MaxScale)
+	}
+
+	secretEnv := spec.SecretEnv
+	configMapEnv := spec.ConfigMapEnv
+	if spec.CicdEnv {
+		secretEnv = mergeEnv(cicdSecretEnv, secretEnv)
+		configMapEnv = mergeEnv(cicdConfigMapEnv, configMapEnv)
+	}
+
+	env := []interface{}{}
+	for _, name := range sortedKeys(spec.Env) {
+		env = append(env, map[string]interface{}{"name": name, "value": spec.Env[name]})
+	}
+	for _, refs := range []struct {
+		kind   string
+		values map[string]string
+	}{{"secretKeyRef", secretEnv}, {"configMapKeyRef", configMapEnv}} {
+		for _, name := range sortedKeys(refs.values) {
+			parts := strings.SplitN(refs.values[name], ":", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+				return nil, fmt.Errorf("the %s of %s must be written NAME:KEY, got %q", refs.kind, name, refs.values[name])
+			}
+			env = append(env, map[string]interface{}{
+				"name": name,
+				"valueFrom": map[string]interface{}{
+					refs.kind: map[string]interface{}{"name": parts[0], "key": parts[1]},
+				},
+			})
+		}
+	}
+
+	container := map[string]interface{}{"image": spec.Image}
+	if len(env) > 0 {
+		container["env"] = env
+	}
+	if spec.Port > 0 || spec.H2C {
+		// Knative routes HTTP/2 without TLS, used by gRPC, to a port named h2c
+		port := map[string]interface{}{"name": "http1"}
+		if spec.H2C {
+			port["name"] = "h2c"
+		}
+		if spec.Port > 0 {
+			port["containerPort"] = spec.Port
+		}
+		container["ports"] = []interface{}{port}
+	}
+
+	annotations := map[string]interface{}{}
+	if spec.MinScale != nil {
+		annotations["autoscaling.knative.dev/minScale"] = strconv.Itoa(*spec.MinScale)
+	}
+	if spec.MaxScale != nil {
+		annotations["autoscaling.knative.dev/maxScale"] = strconv.Itoa(*spec.MaxScale)
+	}
+
+	podSpec := map[string]interface{}{"containers": []interface{}{container}}
+	if spec.Concurrency != nil {
+		podSpec["containerConcurrency"] = *spec.Concurrency
+	}
+	if len(spec.PullSecret) > 0 {
+		podSpec["imagePullSecrets"] = []interface{}{map[string]interface{}{"name": spec.PullSecret}}
+	}
+
+	template := map[string]interface{}{"spec": podSpec}
+	if len(annotations) > 0 {
+		template["metadata"] = map[string]interface{}{"annotations": annotations}
+	}
+
+	metadata := map[string]interface{}{"name": spec.Name, "namespace": spec.Namespace}
+	if spec.ClusterLocal {
+		metadata["labels"] = map[string]interface{}{"serving.knative.dev/visibility": "cluster-local"}
+	}
+
+	// JSON is valid YAML, so the manifest is applied like the templates
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "serving.knative.dev/v1",
+		"kind":       "Service",
+		"metadata":   metadata,
+		"spec":       map[string]interface{}{"template": template},
+	})
+}
+
+func mergeEnv(defaults, env map[string]string) map[string]string {
+	merged := map[string]string{}
+	for name, value := range defaults {
+		merged[name] = value
+	}
+	for name, value := range env {
+		merged[name] = value
+	}
+	return merged
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Deploy creates or updates the Knative Service of a spec and, with wait,
+// returns its URL once its latest revision is ready.
+func Deploy(command *cobra.Command, spec DeploySpec, wait bool, timeout time.Duration) (string, error) {
+	useDefaultKubeconfig(command)
+
+	client, err := getKubeClient()
+	if err != nil {
+		return "", err
+	}
+
+	// regcred is used by default where the cicd installer created it
+	if len(spec.PullSecret) == 0 && !isDryRun() {
+		_, err = client.Get(context.Background(), k8s.Object{APIVersion: "v1", Kind: "Secret", Namespace: spec.Namespace, Name: defaultPullSecret})
+		if err == nil {
+			spec.PullSecret = defaultPullSecret
+		} else if !apierrors.IsNotFound(err) {
+			return "", err
+		}
+	}
+
+	manifest, err := buildKnativeService(spec)
+	if err != nil {
+		return "", err
+	}
+
+	err = client.Apply(context.Background(), manifest, spec.Namespace)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(messages, "Service %s/%s deployed\n", spec.Namespace, spec.Name)
+
+	if !wait || isDryRun() {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = client.Wait(ctx, knativeService(spec.Namespace, spec.Name), latestReady)
+	if err != nil {
+		if res, getErr := client.Get(context.Background(), knativeService(spec.Namespace, spec.Name)); getErr == nil {
+			if message := readyMessage(res); len(message) > 0 {
+				return "", fmt.Errorf("service %s/%s is not ready: %s", spec.Namespace, spec.Name, message)
+			}
+		}
+		return "", err
+	}
+
+	res, err := client.Get(context.Background(), knativeService(spec.Namespace, spec.Name))
+	if err != nil {
+		return "", err
+	}
+	url, _, _ := unstructured.NestedString(res.Object, "status", "url")
+	return url, nil
+}
+
+// latestReady waits for a Knative resource to be ready with its latest spec,
+// an updated Service stays ready with its previous revision meanwhile.
+func latestReady(obj *unstructured.Unstructured) (bool, error) {
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observed < obj.GetGeneration() {
+		return false, nil
+	}
+	return k8s.ConditionTrue("Ready")(obj)
+}
+
+// readyMessage returns the reason and message of the Ready condition when it
+// is not true.
+func readyMessage(obj *unstructured.Unstructured) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" || condition["status"] == "True" {
+			continue
+		}
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		return strings.TrimSpace(reason + " " + message)
+	}
+	return ""
+}
@@ -0,0 +1,390 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// knativeConfigNamespaces gives the namespace of the ConfigMaps of each
+// component, the first part of a config key.
+var knativeConfigNamespaces = map[string]string{
+	"serving":  "knative-serving",
+	"eventing": "knative-eventing",
+}
+
+// valueCheck validates the value of a config key.
+type valueCheck func(value string) error
+
+// knativeConfigKeys are the known keys of the Knative ConfigMaps, by
+// namespace/name. A ConfigMap without keys takes any key, a nil check any
+// value.
+var knativeConfigKeys = map[string]map[string]valueCheck{
+	"knative-serving/config-autoscaler": {
+		"container-concurrency-target-default":    floatValue,
+		"container-concurrency-target-percentage": floatValue,
+		"requests-per-second-target-default":      floatValue,
+		"target-burst-capacity":                   floatValue,
+		"stable-window":                           durationValue,
+		"panic-window-percentage":                 floatValue,
+		"panic-threshold-percentage":              floatValue,
+		"max-scale-up-rate":                       floatValue,
+		"max-scale-down-rate":                     floatValue,
+		"enable-scale-to-zero":                    boolValue,
+		"scale-to-zero-grace-period":              durationValue,
+		"scale-to-zero-pod-retention-period":      durationValue,
+		"pod-autoscaler-class":                    nil,
+		"activator-capacity":                      floatValue,
+		"initial-scale":                           intValue,
+		"allow-zero-initial-scale":                boolValue,
+		"max-scale":                               intValue,
+		"max-scale-limit":                         intValue,
+	},
+	"knative-serving/config-defaults": {
+		"revision-timeout-seconds":           intValue,
+		"max-revision-timeout-seconds":       intValue,
+		"revision-cpu-request":               nil,
+		"revision-memory-request":            nil,
+		"revision-ephemeral-storage-request": nil,
+		"revision-cpu-limit":                 nil,
+		"revision-memory-limit":              nil,
+		"revision-ephemeral-storage-limit":   nil,
+		"container-name-template":            templateValue,
+		"container-concurrency":              intValue,
+		"container-concurrency-max-limit":    intValue,
+		"allow-container-concurrency-zero":   boolValue,
+		"enable-service-links":               oneOf("true", "false", "default"),
+	},
+	"knative-serving/config-deployment": {
+		"queueSidecarImage":              nil,
+		"registriesSkippingTagResolving": nil,
+		"progressDeadline":               durationValue,
+		"queueSidecarCPURequest":         nil,
+	},
+	"knative-serving/config-domain": {},
+	"knative-serving/config-features": {
+		"multi-container":                     featureValue,
+		"kubernetes.podspec-affinity":         featureValue,
+		"kubernetes.podspec-dryrun":           featureValue,
+		"kubernetes.podspec-fieldref":         featureValue,
+		"kubernetes.podspec-nodeselector":     featureValue,
+		"kubernetes.podspec-runtimeclassname": featureValue,
+		"kubernetes.podspec-tolerations":      featureValue,
+		"responsive-revision-gc":              featureValue,
+		"tag-header-based-routing":            featureValue,
+	},
+	"knative-serving/config-gc": {
+		"stale-revision-create-delay":        durationValue,
+		"stale-revision-timeout":             durationValue,
+		"stale-revision-minimum-generations": intValue,
+		"stale-revision-lastpinned-debounce": durationValue,
+	},
+	"knative-serving/config-network": {
+		"ingress.class":                         nil,
+		"certificate.class":                     nil,
+		"domainTemplate":                        templateValue,
+		"tagTemplate":                           templateValue,
+		"autoTLS":                               oneOf("Enabled", "Disabled"),
+		"httpProtocol":                          oneOf("Enabled", "Disabled", "Redirected"),
+		"istio.sidecar.includeOutboundIPRanges": nil,
+	},
+	"knative-serving/config-leader-election": {},
+	"knative-serving/config-logging":         {},
+	"knative-serving/config-observability":   {},
+	"knative-serving/config-tracing":         {},
+	"knative-eventing/config-br-default-channel": {
+		"channelTemplateSpec": nil,
+	},
+	"knative-eventing/config-br-defaults": {
+		"default-br-config": nil,
+	},
+	"knative-eventing/default-ch-webhook": {
+		"default-ch-config": nil,
+	},
+	"knative-eventing/config-ping-defaults": {
+		"dataMaxSize": intValue,
+	},
+	"knative-eventing/config-leader-election": {},
+	"knative-eventing/config-logging":         {},
+	"knative-eventing/config-observability":   {},
+	"knative-eventing/config-tracing":         {},
+}
+
+func boolValue(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+func intValue(value string) error {
+	_, err := strconv.ParseInt(value, 10, 32)
+	return err
+}
+
+func floatValue(value string) error {
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+func durationValue(value string) error {
+	_, err := time.ParseDuration(value)
+	return err
+}
+
+func templateValue(value string) error {
+	_, err := template.New("").Parse(value)
+	return err
+}
+
+func oneOf(values ...string) valueCheck {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("one of %v expected", values)
+	}
+}
+
+var featureValue = oneOf("enabled", "disabled", "allowed")
+
+// configKey is a key of a Knative ConfigMap, written COMPONENT.NAME.KEY for
+// the KEY of the config-NAME ConfigMap of knative-COMPONENT.
+type configKey struct {
+	ConfigMap k8s.Object
+	Key       string
+}
+
+func (k configKey) String() string {
+	return k.ConfigMap.Namespace + "/" + k.ConfigMap.Name + " " + k.Key
+}
+
+// parseConfigKey parses COMPONENT.NAME.KEY, or COMPONENT.NAME for the whole
+// ConfigMap. The ConfigMaps of eventing not named config-NAME are given with
+// their full name.
+func parseConfigKey(key string) (configKey, error) {
+	parts := strings.SplitN(key, ".", 3)
+	namespace, ok := knativeConfigNamespaces[parts[0]]
+	if len(parts) < 2 || !ok {
+		return configKey{}, fmt.Errorf("config keys are written serving.NAME.KEY or eventing.NAME.KEY, got %q", key)
+	}
+
+	name := "config-" + parts[1]
+	if _, known := knativeConfigKeys[namespace+"/"+name]; !known {
+		if _, known := knativeConfigKeys[namespace+"/"+parts[1]]; known {
+			name = parts[1]
+		}
+	}
+
+	k := configKey{
+		ConfigMap: k8s.Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: namespace, Name: name},
+	}
+	if len(parts) == 3 {
+		k.Key = parts[2]
+	}
+	return k, nil
+}
+
+// validateConfig checks a value against the known keys of its ConfigMap,
+// unknown keys are refused unless force is set.
+func validateConfig(key configKey, value string, force bool) error {
+	keys, known := knativeConfigKeys[key.ConfigMap.Namespace+"/"+key.ConfigMap.Name]
+	if !known || len(keys) == 0 {
+		return nil
+	}
+
+	check, known := keys[key.Key]
+	if !known {
+		if force {
+			return nil
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown key %s, one of %v, use --force to set it anyway", key, names)
+	}
+	if check == nil {
+		return nil
+	}
+	if err := check(value); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %s", value, key, err)
+	}
+	return nil
+}
+
+// readConfig returns the data of a ConfigMap, without the _example key
+// documenting it.
+func readConfig(client k8s.Client, configMap k8s.Object) (map[string]string, error) {
+	res, err := client.Get(context.Background(), configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("no ConfigMap %s/%s, is %s installed?", configMap.Namespace, configMap.Name, configMap.Namespace)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := unstructured.NestedStringMap(res.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	delete(data, "_example")
+	return data, nil
+}
+
+// patchConfig merge-patches keys of a ConfigMap, leaving the other keys as
+// they are.
+func patchConfig(configMap k8s.Object, data map[string]string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	return client.Patch(context.Background(), configMap, types.MergePatchType, patch)
+}
+
+// GetKnativeConfig returns the values of the given config key, or of every key
+// of the given ConfigMap, by key.
+func GetKnativeConfig(command *cobra.Command, key string) (map[string]string, error) {
+	useDefaultKubeconfig(command)
+
+	k, err := parseConfigKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readConfig(client, k.ConfigMap)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(key, "."+k.Key)
+	values := map[string]string{}
+	for name, value := range data {
+		if len(k.Key) == 0 || name == k.Key {
+			values[prefix+"."+name] = value
+		}
+	}
+	if len(k.Key) > 0 && len(values) == 0 {
+		return nil, fmt.Errorf("%s is not set", k)
+	}
+	return values, nil
+}
+
+// SetKnativeConfig sets the given KEY=VALUE assignments, printing the diff of
+// each ConfigMap before patching it. With diffOnly, nothing is written.
+func SetKnativeConfig(command *cobra.Command, assignments []string, force, diffOnly bool) error {
+	useDefaultKubeconfig(command)
+
+	changes := map[k8s.Object]map[string]string{}
+	configMaps := []k8s.Object{}
+	for _, assignment := range assignments {
+		i := strings.Index(assignment, "=")
+		if i < 0 {
+			return fmt.Errorf("config set takes KEY=VALUE, got %q", assignment)
+		}
+
+		k, err := parseConfigKey(assignment[:i])
+		if err != nil {
+			return err
+		}
+		if len(k.Key) == 0 {
+			return fmt.Errorf("config set takes a key of %s/%s, got %q", k.ConfigMap.Namespace, k.ConfigMap.Name, assignment[:i])
+		}
+
+		value := assignment[i+1:]
+		err = validateConfig(k, value, force)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := changes[k.ConfigMap]; !ok {
+			changes[k.ConfigMap] = map[string]string{}
+			configMaps = append(configMaps, k.ConfigMap)
+		}
+		changes[k.ConfigMap][k.Key] = value
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, configMap := range configMaps {
+		data, err := readConfig(client, configMap)
+		if err != nil {
+			return err
+		}
+
+		patch := map[string]string{}
+		for key, value := range changes[configMap] {
+			if old, ok := data[key]; !ok || old != value {
+				patch[key] = value
+			}
+		}
+		if len(patch) == 0 {
+			fmt.Fprintf(messages, "%s/%s unchanged\n", configMap.Namespace, configMap.Name)
+			continue
+		}
+
+		fmt.Fprint(messages, configDiff(configMap, data, patch))
+		if diffOnly {
+			continue
+		}
+
+		err = patchConfig(configMap, patch)
+		if err != nil {
+			return fmt.Errorf("unable to patch %s/%s: %s", configMap.Namespace, configMap.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// configDiff shows the keys changed by a patch, like a unified diff.
+func configDiff(configMap k8s.Object, data, patch map[string]string) string {
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s/%s\n+++ %s/%s\n", configMap.Namespace, configMap.Name, configMap.Namespace, configMap.Name)
+	for _, key := range keys {
+		if old, ok := data[key]; ok {
+			diff.WriteString(diffLine("-", key, old))
+		}
+		diff.WriteString(diffLine("+", key, patch[key]))
+	}
+	return diff.String()
+}
+
+// diffLine prefixes every line of a multi-line value, like the YAML templates
+// of eventing.
+func diffLine(sign, key, value string) string {
+	return sign + key + ": " + strings.ReplaceAll(value, "\n", "\n"+sign+"  ") + "\n"
+}
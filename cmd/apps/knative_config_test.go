@@ -0,0 +1,79 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfigKey(t *testing.T) {
+	for key, test := range map[string]struct {
+		namespace string
+		name      string
+		key       string
+		wantErr   bool
+	}{
+		"serving.autoscaler.enable-scale-to-zero":    {namespace: "knative-serving", name: "config-autoscaler", key: "enable-scale-to-zero"},
+		"serving.features.kubernetes.podspec-dryrun": {namespace: "knative-serving", name: "config-features", key: "kubernetes.podspec-dryrun"},
+		"serving.domain":                                {namespace: "knative-serving", name: "config-domain"},
+		"eventing.ping-defaults.dataMaxSize":            {namespace: "knative-eventing", name: "config-ping-defaults", key: "dataMaxSize"},
+		"eventing.default-ch-webhook.default-ch-config": {namespace: "knative-eventing", name: "default-ch-webhook", key: "default-ch-config"},
+		"serving.unknown.key":                           {namespace: "knative-serving", name: "config-unknown", key: "key"},
+		"serving":                                       {wantErr: true},
+		"tekton.pipelines.key":                          {wantErr: true},
+	} {
+		k, err := parseConfigKey(key)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: want an error, got %s", key, k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", key, err)
+			continue
+		}
+		if k.ConfigMap.Namespace != test.namespace || k.ConfigMap.Name != test.name || k.Key != test.key {
+			t.Errorf("%s: want %s/%s %s, got %s", key, test.namespace, test.name, test.key, k)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, test := range []struct {
+		key     string
+		value   string
+		force   bool
+		wantErr string
+	}{
+		{key: "serving.autoscaler.enable-scale-to-zero", value: "false"},
+		{key: "serving.autoscaler.enable-scale-to-zero", value: "no", wantErr: `invalid value "no"`},
+		{key: "serving.autoscaler.stable-window", value: "60s"},
+		{key: "serving.autoscaler.stable-window", value: "60", wantErr: "invalid value"},
+		{key: "serving.autoscaler.max-scale", value: "1.5", wantErr: "invalid value"},
+		{key: "serving.autoscaler.pod-autoscaler-class", value: "hpa.autoscaling.knative.dev"},
+		{key: "serving.network.domainTemplate", value: "{{.Name}}.{{.Namespace}}.{{.Domain}}"},
+		{key: "serving.network.domainTemplate", value: "{{.Name", wantErr: "invalid value"},
+		{key: "serving.network.autoTLS", value: "Enabled"},
+		{key: "serving.network.autoTLS", value: "enabled", wantErr: "one of [Enabled Disabled] expected"},
+		{key: "serving.features.multi-container", value: "allowed"},
+		{key: "serving.autoscaler.unknown", value: "1", wantErr: "unknown key knative-serving/config-autoscaler unknown"},
+		{key: "serving.autoscaler.unknown", value: "1", force: true},
+		{key: "serving.domain.example.com", value: ""},
+		{key: "serving.unknown.key", value: "any"},
+	} {
+		k, err := parseConfigKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = validateConfig(k, test.value, test.force)
+		if len(test.wantErr) == 0 && err != nil {
+			t.Errorf("%s=%s: %s", test.key, test.value, err)
+		}
+		if len(test.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%s=%s: want an error containing %q, got %v", test.key, test.value, test.wantErr, err)
+		}
+	}
+}
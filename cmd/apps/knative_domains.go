@@ -0,0 +1,317 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+var configDomain = k8s.Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: "knative-serving", Name: "config-domain"}
+
+// domainsAnnotation lists the keys of config-domain written from --domain, the
+// only ones removed once they are no longer given.
+const domainsAnnotation = "coolknative/domains"
+
+// magicDomainSuffixes are the wildcard DNS services of --domain-mode, which
+// resolve IP.SUFFIX and any of its subdomains to IP.
+var magicDomainSuffixes = map[string]string{
+	"sslip": "sslip.io",
+	"nipio": "nip.io",
+}
+
+// gatewayIPTimeout bounds the wait for the load balancer of the gateway to get
+// an IP.
+const gatewayIPTimeout = 5 * time.Minute
+
+func addDomainModeFlag(command *cobra.Command) {
+	command.Flags().String("domain-mode", "",
+		"Derive the domain from --public-ip, or the IP of the gateway, without owning one: sslip for IP.sslip.io, nipio for IP.nip.io")
+}
+
+// getMagicDomainSuffix returns the suffix of --domain-mode, empty when the
+// domain is given with --domain.
+func getMagicDomainSuffix(command *cobra.Command) (string, error) {
+	mode, _ := command.Flags().GetString("domain-mode")
+	if len(mode) == 0 {
+		return "", nil
+	}
+	suffix, ok := magicDomainSuffixes[mode]
+	if !ok {
+		return "", fmt.Errorf("--domain-mode requires sslip or nipio, got %q", mode)
+	}
+	return suffix, nil
+}
+
+// magicDomain returns the domain resolving to an IPv4 address with a wildcard
+// DNS service.
+func magicDomain(ip, suffix string) (string, error) {
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+		return "", fmt.Errorf("--domain-mode requires an IPv4 address, got %q", ip)
+	}
+	return ip + "." + suffix, nil
+}
+
+func isMagicDomain(domain string) bool {
+	for _, suffix := range magicDomainSuffixes {
+		if strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// installedDefaultDomain returns the default domain of the installed
+// knative-serving, the key of config-domain without a selector, empty when
+// knative-serving is not installed.
+func installedDefaultDomain() (string, error) {
+	client, err := getKubeClient()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Get(context.Background(), configDomain)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, _, _ := unstructured.NestedStringMap(res.Object, "data")
+	delete(data, "_example")
+	defaults := []string{}
+	for name, value := range data {
+		if len(strings.TrimSpace(value)) == 0 {
+			defaults = append(defaults, name)
+		}
+	}
+	if len(defaults) > 1 {
+		sort.Strings(defaults)
+		return "", fmt.Errorf("config-domain of knative-serving has several default domains %v, give one with --domain", defaults)
+	}
+	if len(defaults) == 0 {
+		return "", nil
+	}
+	return defaults[0], nil
+}
+
+// gatewayIP waits for the load balancer of a gateway to get an IP.
+func gatewayIP(gateway k8s.Object) (string, error) {
+	if isDryRun() {
+		return "", fmt.Errorf("--domain-mode requires --public-ip with --dry-run, the IP of %s is only known once installed", gateway)
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(messages, "Waiting for the IP of %s\n", gateway)
+	var ip string
+	ctx, cancel := context.WithTimeout(context.Background(), gatewayIPTimeout)
+	defer cancel()
+	err = client.Wait(ctx, gateway, func(obj *unstructured.Unstructured) (bool, error) {
+		ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+		for _, i := range ingress {
+			entry, _ := i.(map[string]interface{})
+			if address, _, _ := unstructured.NestedString(entry, "ip"); len(address) > 0 {
+				ip = address
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s got no IP, give it with --public-ip: %s", gateway, err)
+	}
+	return ip, nil
+}
+
+// knativeDomain is a domain of knative-serving, given to the services matching
+// its selector, or to all the others when it has none.
+type knativeDomain struct {
+	Name     string
+	Selector map[string]string
+	// Internal domains are only reached from inside the corporate network,
+	// they get neither a certificate nor a DNS record.
+	Internal bool
+}
+
+// internalDomainOption marks an internal domain in a --domain value.
+const internalDomainOption = "internal"
+
+// parseKnativeDomains parses the --domain values, written DOMAIN or
+// DOMAIN:KEY=VALUE,... for the services labelled KEY=VALUE, followed by
+// :internal for an internal domain. Exactly one of them has no selector, the
+// default domain, written DOMAIN::internal when it is internal.
+func parseKnativeDomains(values []string) ([]knativeDomain, error) {
+	domains := []knativeDomain{}
+	seen := map[string]bool{}
+	defaults := 0
+	for _, value := range values {
+		value = strings.Trim(value, "\"")
+		parts := strings.SplitN(value, ":", 3)
+
+		domain := knativeDomain{Name: parts[0]}
+		if len(domain.Name) == 0 {
+			return nil, fmt.Errorf("--domain requires DOMAIN or DOMAIN:KEY=VALUE,..., optionally followed by :internal, got %q", value)
+		}
+		if seen[domain.Name] {
+			return nil, fmt.Errorf("--domain %s is given twice", domain.Name)
+		}
+		seen[domain.Name] = true
+
+		if len(parts) == 3 {
+			if parts[2] != internalDomainOption {
+				return nil, fmt.Errorf("unknown option %q of --domain %s, only %s is supported", parts[2], domain.Name, internalDomainOption)
+			}
+			domain.Internal = true
+		}
+
+		if len(parts) == 2 || (len(parts) == 3 && len(parts[1]) > 0) {
+			selector, err := labels.ConvertSelectorToLabelsMap(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector of --domain %s: %s", domain.Name, err)
+			}
+			if len(selector) == 0 {
+				return nil, fmt.Errorf("--domain %s has an empty selector", domain.Name)
+			}
+			domain.Selector = selector
+		} else {
+			defaults++
+		}
+		domains = append(domains, domain)
+	}
+
+	if defaults != 1 {
+		return nil, fmt.Errorf("exactly one --domain without a selector is required, the default domain, got %d", defaults)
+	}
+	return domains, nil
+}
+
+// domainNames returns the names of the domains.
+func domainNames(domains []knativeDomain) []string {
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+	return names
+}
+
+// publicDomainNames returns the names of the domains which are not internal,
+// those getting a certificate and a DNS record.
+func publicDomainNames(domains []knativeDomain) []string {
+	names := []string{}
+	for _, domain := range domains {
+		if !domain.Internal {
+			names = append(names, domain.Name)
+		}
+	}
+	return names
+}
+
+// selectorString gives the selector of a domain like kubectl --selector.
+func (d knativeDomain) selectorString() string {
+	return labels.SelectorFromSet(d.Selector).String()
+}
+
+// applyKnativeDomains writes the domains into config-domain. The domains
+// written by a previous install and no longer given are removed, as Knative
+// takes the most specific selector and a stale default domain would be kept.
+// The keys set with "config set" are left as they are.
+func applyKnativeDomains(domains []knativeDomain) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Get(context.Background(), configDomain)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{}
+	for _, name := range strings.Split(res.GetAnnotations()[domainsAnnotation], ",") {
+		if len(name) > 0 {
+			data[name] = nil
+		}
+	}
+	for _, domain := range domains {
+		data[domain.Name] = ""
+		if len(domain.Selector) > 0 {
+			selector, err := yaml.Marshal(map[string]interface{}{"selector": domain.Selector})
+			if err != nil {
+				return err
+			}
+			data[domain.Name] = string(selector)
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{domainsAnnotation: strings.Join(domainNames(domains), ",")},
+		},
+		"data": data,
+	})
+	if err != nil {
+		return err
+	}
+	return client.Patch(context.Background(), configDomain, types.MergePatchType, patch)
+}
+
+// domainEndpoints describes which services get which domain, with the address
+// of a service and the DNS records pointing at the gateway. The internal
+// domains have no certificate and their records are left to the corporate DNS.
+func domainEndpoints(domains []knativeDomain, domainTemplate, publicIp string, https bool) (string, error) {
+	tmpl, err := template.New("domainTemplate").Parse(strings.Trim(domainTemplate, "\""))
+	if err != nil {
+		return "", fmt.Errorf("invalid --domain-template: %s", err)
+	}
+
+	// The default domain is listed last, it serves the services matching no
+	// selector
+	sorted := append([]knativeDomain{}, domains...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Selector) > 0 && len(sorted[j].Selector) == 0
+	})
+
+	var out strings.Builder
+	for _, domain := range sorted {
+		var host bytes.Buffer
+		err := tmpl.Execute(&host, map[string]string{"Name": "NAME", "Namespace": "NAMESPACE", "Domain": domain.Name})
+		if err != nil {
+			return "", fmt.Errorf("invalid --domain-template: %s", err)
+		}
+
+		scheme := "http"
+		if https && !domain.Internal {
+			scheme = "https"
+		}
+
+		if len(domain.Selector) > 0 {
+			fmt.Fprintf(&out, "# Services labelled %s: %s://%s\n", domain.selectorString(), scheme, host.String())
+		} else {
+			fmt.Fprintf(&out, "# Other services: %s://%s\n", scheme, host.String())
+		}
+		if publicIp != "localhost" && !domain.Internal && !isMagicDomain(domain.Name) {
+			fmt.Fprintf(&out, "#   DNS record: *.%s A %s\n", domain.Name, publicIp)
+		}
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// newFakeClient returns a client of a fake cluster holding ConfigMaps.
+func newFakeClient(objects ...runtime.Object) k8s.Client {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+
+	listKinds := map[schema.GroupVersionResource]string{{Version: "v1", Resource: "configmaps"}: "ConfigMapList"}
+	return k8s.NewForClients(fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...), mapper)
+}
+
+func TestParseKnativeDomains(t *testing.T) {
+	for name, test := range map[string]struct {
+		values  []string
+		want    []knativeDomain
+		wantErr string
+	}{
+		"default": {
+			values: []string{`"mydomain.com"`},
+			want:   []knativeDomain{{Name: "mydomain.com"}},
+		},
+		"selectors": {
+			values: []string{"mydomain.com", "api.mydomain.com:app=api,tier=front"},
+			want: []knativeDomain{
+				{Name: "mydomain.com"},
+				{Name: "api.mydomain.com", Selector: map[string]string{"app": "api", "tier": "front"}},
+			},
+		},
+		"internal": {
+			values: []string{"mydomain.com", "svc.corp.local:network=corp:internal"},
+			want: []knativeDomain{
+				{Name: "mydomain.com"},
+				{Name: "svc.corp.local", Selector: map[string]string{"network": "corp"}, Internal: true},
+			},
+		},
+		"internal default": {
+			values: []string{"svc.corp.local::internal", "mydomain.com:network=public"},
+			want: []knativeDomain{
+				{Name: "svc.corp.local", Internal: true},
+				{Name: "mydomain.com", Selector: map[string]string{"network": "public"}},
+			},
+		},
+		"selector is only a label": {
+			values: []string{"mydomain.com", "svc.corp.local:visibility=internal"},
+			want: []knativeDomain{
+				{Name: "mydomain.com"},
+				{Name: "svc.corp.local", Selector: map[string]string{"visibility": "internal"}},
+			},
+		},
+		"unknown option": {
+			values:  []string{"mydomain.com", "svc.corp.local:network=corp:private"},
+			wantErr: `unknown option "private" of --domain svc.corp.local`,
+		},
+		"empty name": {
+			values:  []string{":app=api"},
+			wantErr: "--domain requires DOMAIN",
+		},
+		"twice": {
+			values:  []string{"mydomain.com", "mydomain.com:app=api"},
+			wantErr: "--domain mydomain.com is given twice",
+		},
+		"empty selector": {
+			values:  []string{"mydomain.com", "api.mydomain.com:"},
+			wantErr: "--domain api.mydomain.com has an empty selector",
+		},
+		"invalid selector": {
+			values:  []string{"mydomain.com", "api.mydomain.com:app"},
+			wantErr: "invalid selector of --domain api.mydomain.com",
+		},
+		"no default": {
+			values:  []string{"api.mydomain.com:app=api"},
+			wantErr: "exactly one --domain without a selector is required, the default domain, got 0",
+		},
+		"several defaults": {
+			values:  []string{"mydomain.com", "other.com"},
+			wantErr: "exactly one --domain without a selector is required, the default domain, got 2",
+		},
+	} {
+		domains, err := parseKnativeDomains(test.values)
+		if len(test.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: want an error containing %q, got %v", name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(domains, test.want) {
+			t.Errorf("%s: want %v, got %v", name, test.want, domains)
+		}
+	}
+}
+
+func TestApplyKnativeDomains(t *testing.T) {
+	for name, test := range map[string]struct {
+		data           map[string]interface{}
+		annotation     string
+		values         []string
+		want           map[string]string
+		wantAnnotation string
+	}{
+		"first install": {
+			data:   map[string]interface{}{"example.com": ""},
+			values: []string{"mydomain.com", "svc.corp.local:network=corp:internal"},
+			want: map[string]string{
+				"example.com":    "",
+				"mydomain.com":   "",
+				"svc.corp.local": "selector:\n  network: corp\n",
+			},
+			wantAnnotation: "mydomain.com,svc.corp.local",
+		},
+		"domains of a previous install": {
+			data: map[string]interface{}{
+				"old.com":        "",
+				"svc.corp.local": "selector:\n  network: corp\n",
+				"set.com":        "selector:\n  app: api\n",
+			},
+			annotation: "old.com,svc.corp.local",
+			values:     []string{"mydomain.com"},
+			want: map[string]string{
+				"mydomain.com": "",
+				"set.com":      "selector:\n  app: api\n",
+			},
+			wantAnnotation: "mydomain.com",
+		},
+		"same domains": {
+			data:           map[string]interface{}{"mydomain.com": ""},
+			annotation:     "mydomain.com",
+			values:         []string{"mydomain.com"},
+			want:           map[string]string{"mydomain.com": ""},
+			wantAnnotation: "mydomain.com",
+		},
+	} {
+		configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": configDomain.Name, "namespace": configDomain.Namespace},
+			"data":       test.data,
+		}}
+		if len(test.annotation) > 0 {
+			configMap.SetAnnotations(map[string]string{domainsAnnotation: test.annotation})
+		}
+		kubeClient = newFakeClient(configMap)
+
+		domains, err := parseKnativeDomains(test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applyKnativeDomains(domains)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		res, err := kubeClient.Get(context.Background(), configDomain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, _ := unstructured.NestedStringMap(res.Object, "data")
+		if !reflect.DeepEqual(data, test.want) {
+			t.Errorf("%s: want data %v, got %v", name, test.want, data)
+		}
+		if annotation := res.GetAnnotations()[domainsAnnotation]; annotation != test.wantAnnotation {
+			t.Errorf("%s: want annotation %q, got %q", name, test.wantAnnotation, annotation)
+		}
+	}
+	kubeClient = nil
+}
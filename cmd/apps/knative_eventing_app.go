@@ -5,96 +5,104 @@ package apps
 import (
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/versions"
 	"github.com/spf13/cobra"
-	"os/exec"
 )
 
-type KnativeEventingNatsChannelInputData struct {}
+type KnativeEventingNatsChannelInputData struct{}
 
 func MakeInstallKnativeEventing() *cobra.Command {
 	var knativeEventing = &cobra.Command{
-		Use:          "knative-eventing",
-		Short:        "Install knative-eventing",
-		Long:         `Install knative-eventing`,
-		Example:      `  coolknative install knative-eventing"`,
+		Use:   "knative-eventing",
+		Short: "Install knative-eventing",
+		Long:  `Install knative-eventing`,
+		Example: `  coolknative install knative-eventing
+  coolknative install knative-eventing --version v0.18.0`,
 		SilenceUsage: true,
 	}
 
+	addVersionFlags(knativeEventing, "knative-eventing")
+
 	knativeEventing.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
 
-		res, err := kubectlTask("apply", "-f",
-			"https://github.com/knative/eventing/releases/download/v0.18.0/eventing-crds.yaml")
+		release, err := getRelease(command, "knative-eventing")
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative/eventing/releases/download/v0.18.0/eventing-core.yaml")
+		err = applyManifests("", release.Version, knativeEventingManifests(release))
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
+
+		defaultNatssUrl := "nats://nats.default.svc.cluster.local:4222"
+		for _, deployName := range []string{"natss-ch-controller", "natss-ch-dispatcher"} {
+			err = addEnvToDeploy(deployName, "DEFAULT_NATSS_URL", defaultNatssUrl)
+			if err != nil {
+				return err
+			}
+			err = addEnvToDeploy(deployName, "DEFAULT_CLUSTER_ID", "nats-streaming")
+			if err != nil {
+				return err
+			}
 		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative/eventing/releases/download/v0.18.0/mt-channel-broker.yaml")
+		inputData := KnativeEventingNatsChannelInputData{}
+		err = buildApplyYAML(inputData, knativeEventingNatsChannelYamlTemplate, "temp_knative_eventing_natss_channel.yaml")
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
-		
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative/eventing/releases/download/v0.18.0/eventing-sugar-controller.yaml")
+
+		err = recordVersion("knative-eventing", release.Version)
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative-sandbox/eventing-natss/releases/download/v0.18.0/eventing-natss.yaml")
+		fmt.Fprintln(messages, KnativeEventingInstallMsg)
+
+		return nil
+	}
+
+	return knativeEventing
+}
+
+func MakeUninstallKnativeEventing() *cobra.Command {
+	var knativeEventing = &cobra.Command{
+		Use:          "knative-eventing",
+		Short:        "Uninstall knative-eventing",
+		Long:         `Uninstall knative-eventing and the natss channel`,
+		Example:      `  coolknative uninstall knative-eventing`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(knativeEventing, "knative-eventing")
+
+	knativeEventing.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "knative-eventing")
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
 
-		defaultNatssUrl := "nats://nats.default.svc.cluster.local:4222"
-		addEnv := "DEFAULT_NATSS_URL=" + defaultNatssUrl
-		err2 := addEnvToDeploy("natss-ch-controller", addEnv, err)
-		if err2 != nil {
-			return err2
-		}
-		err2 = addEnvToDeploy("natss-ch-dispatcher", addEnv, err)
-		if err2 != nil {
-			return err2
-		}
-		addEnv = "DEFAULT_CLUSTER_ID=nats-streaming"
-		err2 = addEnvToDeploy("natss-ch-controller", addEnv, err)
-		if err2 != nil {
-			return err2
+		err = buildDeleteYAML(KnativeEventingNatsChannelInputData{}, knativeEventingNatsChannelYamlTemplate)
+		if err != nil {
+			return err
 		}
-		err2 = addEnvToDeploy("natss-ch-dispatcher", addEnv, err)
-		if err2 != nil {
-			return err2
+
+		err = deleteManifests("", release.Version, knativeEventingManifests(release), keepCRDs)
+		if err != nil {
+			return err
 		}
 
-		inputData := KnativeEventingNatsChannelInputData{}
-		err = buildApplyYAML(inputData, knativeEventingNatsChannelYamlTemplate, "temp_knative_eventing_natss_channel.yaml")
+		err = forgetVersion("knative-eventing")
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(KnativeEventingInstallMsg)
+		fmt.Fprintln(messages, "Knative Eventing has been uninstalled.")
 
 		return nil
 	}
@@ -102,14 +110,40 @@ func MakeInstallKnativeEventing() *cobra.Command {
 	return knativeEventing
 }
 
-func addEnvToDeploy(deployName, addEnv string, err error) error {
-	cmd := exec.Command("kubectl", "-n", "knative-eventing", "set", "env", "deployment/"+deployName, addEnv)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println(fmt.Sprint(err) + ": " + string(output))
-		return err
+var knativeEventingReadiness = componentHealth{
+	Name: "knative-eventing",
+	Checks: []readinessCheck{
+		deploymentAvailable("knative-eventing", "eventing-controller"),
+		deploymentAvailable("knative-eventing", "eventing-webhook"),
+		deploymentAvailable("knative-eventing", "mt-broker-controller"),
+		deploymentAvailable("knative-eventing", "natss-ch-controller"),
+		deploymentAvailable("knative-eventing", "natss-ch-dispatcher"),
+	},
+	VersionLabel: "eventing.knative.dev/release",
+}
+
+func knativeEventingManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/knative/eventing/releases/download/%s/eventing-crds.yaml", release.Version),
+		fmt.Sprintf("https://github.com/knative/eventing/releases/download/%s/eventing-core.yaml", release.Version),
+		fmt.Sprintf("https://github.com/knative/eventing/releases/download/%s/mt-channel-broker.yaml", release.Version),
+		fmt.Sprintf("https://github.com/knative/eventing/releases/download/%s/eventing-sugar-controller.yaml", release.Version),
+		natssChannelManifest(release.Parts["eventing-natss"]),
 	}
-	return nil
+}
+
+// natssChannelManifest returns the manifest of the natss channel, released
+// with eventing-contrib up to v0.17.
+func natssChannelManifest(version string) string {
+	switch versions.Minor(version) {
+	case "v0.16", "v0.17":
+		return fmt.Sprintf("https://github.com/knative/eventing-contrib/releases/download/%s/natss-channel.yaml", version)
+	}
+	return fmt.Sprintf("https://github.com/knative-sandbox/eventing-natss/releases/download/%s/eventing-natss.yaml", version)
+}
+
+func addEnvToDeploy(deployName, name, value string) error {
+	return setDeploymentEnv("knative-eventing", deployName, name, value)
 }
 
 const KnativeEventingInfoMsg = `
@@ -122,7 +156,6 @@ const KnativeEventingInstallMsg = `
 =======================================================================` +
 	"\n\n" + KnativeEventingInfoMsg + "\n\n" + pkg.ThanksForUsing
 
-
 var knativeEventingNatsChannelYamlTemplate = `
 apiVersion: v1
 kind: ConfigMap
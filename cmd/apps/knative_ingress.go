@@ -0,0 +1,200 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/eskersoftware/coolknative/pkg/versions"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const defaultKnativeIngress = "kourier"
+
+// knativeServingIngressKey records the ingress of knative-serving next to its
+// version, for the status and uninstall commands.
+const knativeServingIngressKey = "knative-serving.ingress"
+
+// knativeIngress is a networking layer of knative-serving.
+type knativeIngress struct {
+	// Class is the ingress class set in the config-network ConfigMap.
+	Class string
+	// Manifests returns the release manifests of the net-* controller.
+	Manifests func(versions.Release) []string
+	// Gateway is the LoadBalancer Service receiving the traffic, given the
+	// --public-ip.
+	Gateway k8s.Object
+	// ExistingGateway is set when the gateway is not installed with the
+	// controller but must already run in the cluster.
+	ExistingGateway bool
+	// TLSNamespace is the namespace of the Secret of the certificate served
+	// by the gateway, empty when it cannot serve the wildcard certificate.
+	TLSNamespace string
+	// Checks are the readiness checks of the controller and the gateway.
+	Checks []readinessCheck
+}
+
+var knativeIngresses = map[string]knativeIngress{
+	"kourier": {
+		Class: "kourier.ingress.networking.knative.dev",
+		Manifests: func(release versions.Release) []string {
+			return []string{
+				fmt.Sprintf("https://github.com/knative-sandbox/net-kourier/releases/download/%s/kourier.yaml", release.Parts["net-kourier"]),
+			}
+		},
+		Gateway:      k8s.Object{APIVersion: "v1", Kind: "Service", Namespace: "kourier-system", Name: "kourier"},
+		TLSNamespace: "knative-serving",
+		Checks: []readinessCheck{
+			deploymentAvailable("knative-serving", "3scale-kourier-control"),
+			deploymentAvailable("kourier-system", "3scale-kourier-gateway"),
+		},
+	},
+	"contour": {
+		Class: "contour.ingress.networking.knative.dev",
+		Manifests: func(release versions.Release) []string {
+			return []string{
+				fmt.Sprintf("https://github.com/knative-sandbox/net-contour/releases/download/%s/net-contour.yaml", release.Parts["net-contour"]),
+			}
+		},
+		Gateway:         k8s.Object{APIVersion: "v1", Kind: "Service", Namespace: "contour-external", Name: "envoy"},
+		ExistingGateway: true,
+		Checks: []readinessCheck{
+			deploymentAvailable("knative-serving", "net-contour-controller"),
+			deploymentAvailable("contour-external", "contour"),
+		},
+	},
+	"istio": {
+		Class: "istio.ingress.networking.knative.dev",
+		Manifests: func(release versions.Release) []string {
+			return []string{
+				fmt.Sprintf("https://github.com/knative-sandbox/net-istio/releases/download/%s/net-istio.yaml", release.Parts["net-istio"]),
+			}
+		},
+		Gateway:         k8s.Object{APIVersion: "v1", Kind: "Service", Namespace: "istio-system", Name: "istio-ingressgateway"},
+		ExistingGateway: true,
+		TLSNamespace:    "istio-system",
+		Checks: []readinessCheck{
+			deploymentAvailable("knative-serving", "networking-istio"),
+			deploymentAvailable("istio-system", "istio-ingressgateway"),
+		},
+	},
+}
+
+// knativeIngressNames returns the names of the networking layers, sorted.
+func knativeIngressNames() []string {
+	names := []string{}
+	for name := range knativeIngresses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getKnativeIngress(name string) (knativeIngress, error) {
+	ingress, ok := knativeIngresses[name]
+	if !ok {
+		return knativeIngress{}, fmt.Errorf("--ingress requires one of %v, got %q", knativeIngressNames(), name)
+	}
+	return ingress, nil
+}
+
+// checkGateway verifies that the gateway of a networking layer which is not
+// installed with its controller already runs in the cluster.
+func checkGateway(name string, ingress knativeIngress) error {
+	if !ingress.ExistingGateway || isDryRun() {
+		return nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Get(context.Background(), ingress.Gateway)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("--ingress %s uses the gateway already running in the cluster, but %s was not found", name, ingress.Gateway)
+	}
+	return err
+}
+
+// serveCertificates makes the gateway of a networking layer serve the wildcard
+// certificates kept in Secrets of its TLSNamespace, given by domain. Kourier
+// serves a single certificate for all the domains.
+func serveCertificates(name string, secrets map[string]string) error {
+	switch name {
+	case "kourier":
+		if len(secrets) != 1 {
+			return fmt.Errorf("the gateway of --ingress kourier serves a single certificate, got %d", len(secrets))
+		}
+		err := setDeploymentEnv("knative-serving", "3scale-kourier-control", "CERTS_SECRET_NAMESPACE", "knative-serving")
+		if err != nil {
+			return err
+		}
+		return setDeploymentEnv("knative-serving", "3scale-kourier-control", "CERTS_SECRET_NAME", secrets[sortedKeys(secrets)[0]])
+	case "istio":
+		return addIstioHTTPSServers(secrets)
+	}
+	return fmt.Errorf("the gateway of --ingress %s cannot serve the wildcard certificate", name)
+}
+
+// addIstioHTTPSServers adds an HTTPS server by domain to the gateway of
+// net-istio, the ingress gateway of istio reads the certificates from its own
+// namespace. The servers added by a previous install are replaced.
+func addIstioHTTPSServers(secrets map[string]string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	gateway := k8s.Object{APIVersion: "networking.istio.io/v1alpha3", Kind: "Gateway", Namespace: "knative-serving", Name: "knative-ingress-gateway"}
+	res, err := client.Get(context.Background(), gateway)
+	if err != nil {
+		return err
+	}
+
+	current, _, _ := unstructured.NestedSlice(res.Object, "spec", "servers")
+	servers := []interface{}{}
+	for _, s := range current {
+		server, _ := s.(map[string]interface{})
+		secret, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		if secret == tlsSecretName || strings.HasPrefix(secret, wildcardCertificatePrefix) {
+			continue
+		}
+		servers = append(servers, s)
+	}
+	for _, domain := range sortedKeys(secrets) {
+		servers = append(servers, map[string]interface{}{
+			"hosts": []interface{}{"*." + domain, domain},
+			"port":  map[string]interface{}{"name": "https-" + strings.ReplaceAll(domain, ".", "-"), "number": int64(443), "protocol": "HTTPS"},
+			"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": secrets[domain]},
+		})
+	}
+
+	// A merge patch replaces the whole list of servers
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"servers": servers},
+	})
+	if err != nil {
+		return err
+	}
+	return client.Patch(context.Background(), gateway, types.MergePatchType, patch)
+}
+
+// knativeServingHealth checks knative-serving with its networking layer.
+func knativeServingHealth(ingressName string) componentHealth {
+	ingress, ok := knativeIngresses[ingressName]
+	if !ok {
+		ingress = knativeIngresses[defaultKnativeIngress]
+	}
+
+	health := knativeServingReadiness
+	health.Checks = append(append([]readinessCheck{}, knativeServingReadiness.Checks...), ingress.Checks...)
+	return health
+}
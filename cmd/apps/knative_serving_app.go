@@ -3,140 +3,281 @@
 package apps
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/eskersoftware/coolknative/pkg/versions"
 	"github.com/spf13/cobra"
-	"os/exec"
+	"io/ioutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
 	"strings"
+	"time"
 )
 
-type KnativeServingConfigMapInputData struct {
-	DomainTemplate    string
-	Domain        string
-	EnableScaleToZero string
+type KnativeServingTlsInputData struct {
+	Domains         []string
+	SecretNamespace string
+	Issuer          string
+	CASecret        string
+	GenerateCA      bool
+	AcmeEmail       string
+	AcmeServer      string
+	AcmeSolvers     string
 }
 
+// Issuers of --tls-issuer, the CA and ACME issuers are named after them.
+const (
+	tlsIssuerSelfSigned = "selfsigned"
+	tlsIssuerCA         = "ca"
+	tlsIssuerAcme       = "acme"
+)
+
+// tlsSecretName is the secret of the wildcard certificate, read by the
+// gateway.
+const tlsSecretName = "tls"
+
+// generatedCASecret is the Secret of the cert-manager namespace holding the CA
+// generated for --tls-issuer ca.
+const generatedCASecret = "coolknative-ca"
+
+// certificateTimeout bounds the wait for the wildcard certificate with --wait,
+// an ACME DNS-01 challenge takes a few minutes.
+const certificateTimeout = 10 * time.Minute
+
 func MakeInstallKnativeServing() *cobra.Command {
 	var knativeServing = &cobra.Command{
-		Use:          "knative-serving",
-		Short:        "Install knative-serving",
-		Long:         `Install knative-serving.`,
-		Example:      `  coolknative install knative-serving  --domain-template "{{.Name}}-{{.Namespace}}.{{.Domain}} --domain mydomain.com"`,
+		Use:   "knative-serving",
+		Short: "Install knative-serving",
+		Long: `Install knative-serving with its networking layer: Kourier, or the Contour or
+Istio already running in the cluster.`,
+		Example: `  coolknative install knative-serving  --domain-template "{{.Name}}-{{.Namespace}}.{{.Domain}} --domain mydomain.com"
+  coolknative install knative-serving --ingress istio --public-ip 203.0.113.10
+  coolknative install knative-serving --domain mydomain.com --domain svc.corp.local:network=corp:internal
+  coolknative install knative-serving --domain-mode sslip --public-ip 203.0.113.10
+  coolknative install knative-serving --domain mydomain.com --tls-issuer ca
+  coolknative install knative-serving --domain mydomain.com --tls-issuer acme --acme-email me@mydomain.com --acme-solvers solvers.yaml`,
 		SilenceUsage: true,
 	}
 
 	knativeServing.Flags().StringP("domain-template", "d", `"{{.Name}}-{{.Namespace}}.{{.Domain}}"`, "Custom domain template")
-	knativeServing.Flags().StringP("domain", "n", "example.com", "Custom domain name")
+	knativeServing.Flags().StringArrayP("domain", "n", []string{"example.com"},
+		"Custom domain name, DOMAIN:KEY=VALUE,... for the services with these labels only, followed by :internal for a domain without certificate nor DNS record, repeat it for several domains")
+	addDomainModeFlag(knativeServing)
 	knativeServing.Flags().StringP("public-ip", "i", "localhost", "Public ip for dns for domain")
 	knativeServing.Flags().StringP("enable-scale-to-zero", "z", "true", "Enable scale to zero")
+	knativeServing.Flags().String("ingress", defaultKnativeIngress,
+		"Networking layer: kourier, or contour or istio to use the gateway already running in the cluster")
+	knativeServing.Flags().String("tls-issuer", "",
+		"Issue and renew a wildcard certificate for each --domain which is not internal with cert-manager: selfsigned or ca for development, acme for production")
+	knativeServing.Flags().String("ca-secret", "",
+		"With --tls-issuer ca, Secret of the cert-manager namespace holding the CA, a CA is generated when empty")
+	knativeServing.Flags().String("acme-email", "", "With --tls-issuer acme, email of the ACME account")
+	knativeServing.Flags().String("acme-server", "https://acme-v02.api.letsencrypt.org/directory", "With --tls-issuer acme, URL of the ACME directory")
+	knativeServing.Flags().String("acme-solvers", "",
+		"With --tls-issuer acme, YAML file of the cert-manager solvers, a wildcard certificate requires a DNS-01 solver")
+
+	addVersionFlags(knativeServing, "knative-serving")
 
 	knativeServing.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
 
+		release, err := getRelease(command, "knative-serving")
+		if err != nil {
+			return err
+		}
+
 		domainTemplate, _ := knativeServing.Flags().GetString("domain-template")
 		if !strings.HasPrefix(domainTemplate, "\"") {
 			domainTemplate = "\"" + domainTemplate + "\""
 		}
 
-		domain, _ := knativeServing.Flags().GetString("domain")
+		domainValues, _ := knativeServing.Flags().GetStringArray("domain")
+		magicSuffix, err := getMagicDomainSuffix(command)
+		if err != nil {
+			return err
+		}
+		if len(magicSuffix) > 0 {
+			// The default domain is derived from the IP once it is known, the
+			// --domain values then only add domains with a selector
+			if !command.Flags().Changed("domain") {
+				domainValues = []string{}
+			}
+			for _, value := range domainValues {
+				if !strings.Contains(value, ":") {
+					return fmt.Errorf("--domain-mode gives the default domain, --domain %s needs a selector", value)
+				}
+			}
+			domainValues = append([]string{magicSuffix}, domainValues...)
+		}
+		domains, err := parseKnativeDomains(domainValues)
+		if err != nil {
+			return err
+		}
 		enableScaleToZero, _ := knativeServing.Flags().GetString("enable-scale-to-zero")
 
 		publicIp, _ := knativeServing.Flags().GetString("public-ip")
 		if strings.HasPrefix(publicIp, "\"") {
 			publicIp = publicIp[1 : len(publicIp)-1]
 		}
+		// The IP is checked before anything is applied, the one of the gateway
+		// only once it is installed
+		if len(magicSuffix) > 0 && publicIp != "localhost" {
+			if _, err := magicDomain(publicIp, magicSuffix); err != nil {
+				return err
+			}
+		}
 
 		if strings.HasPrefix(enableScaleToZero, "\"") {
 			enableScaleToZero = enableScaleToZero[1 : len(enableScaleToZero)-1]
 		}
-		res, err := kubectlTask("apply", "-f",
-			"https://github.com/knative/serving/releases/download/v0.18.0/serving-crds.yaml")
+
+		ingressName, _ := knativeServing.Flags().GetString("ingress")
+		ingress, err := getKnativeIngress(ingressName)
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative/serving/releases/download/v0.18.0/serving-core.yaml")
+		tlsIssuer, _ := knativeServing.Flags().GetString("tls-issuer")
+		tlsInputData, err := getTlsInputData(command, publicDomainNames(domains))
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
+		if len(tlsIssuer) > 0 && len(ingress.TLSNamespace) == 0 {
+			return fmt.Errorf("--tls-issuer is not supported with --ingress %s, configure the certificate of its gateway instead", ingressName)
+		}
+		if len(tlsIssuer) > 0 && len(tlsInputData.Domains) == 0 {
+			return fmt.Errorf("--tls-issuer requires a --domain which is not internal")
+		}
+		if len(tlsIssuer) > 0 && ingressName == "kourier" && len(tlsInputData.Domains) > 1 {
+			return fmt.Errorf("--ingress kourier serves a single certificate, --tls-issuer requires a single domain which is not internal with it, got %s",
+				strings.Join(tlsInputData.Domains, ", "))
+		}
+		tlsInputData.SecretNamespace = ingress.TLSNamespace
+		if tlsIssuer == tlsIssuerAcme && len(magicSuffix) > 0 {
+			return fmt.Errorf("--tls-issuer acme cannot issue the wildcard certificate of --domain-mode, whose DNS-01 challenge cannot be solved, use selfsigned or ca")
 		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative/serving/releases/download/v0.18.0/serving-hpa.yaml")
+		err = checkGateway(ingressName, ingress)
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
 
-		res, err = kubectlTask("apply", "-f",
-			"https://github.com/knative-sandbox/net-kourier/releases/download/v0.18.0/kourier.yaml")
+		err = applyManifests("", release.Version, append(knativeServingManifests(release), ingress.Manifests(release)...))
 		if err != nil {
 			return err
 		}
-		if res.ExitCode != 0 {
-			return fmt.Errorf(res.Stderr)
-		}
-		patch := "{\"data\":{\"ingress.class\":\"kourier.ingress.networking.knative.dev\"}}"
-		cmd := exec.Command("kubectl", "-n", "knative-serving", "patch", "cm", "config-network", "--type", "merge", "--patch", patch)
-		output, err := cmd.CombinedOutput()
 
+		client, err := getKubeClient()
 		if err != nil {
-			fmt.Println(fmt.Sprint(err) + ": " + string(output))
 			return err
 		}
 
-		if publicIp != "localhost" {
-			fmt.Println(publicIp)
-
-
-			cmd = exec.Command("kubectl", "-n", "knative-serving", "set", "env", "deployment/3scale-kourier-control", "CERTS_SECRET_NAMESPACE=knative-serving")
-			output, err = cmd.CombinedOutput()
+		if len(magicSuffix) > 0 {
+			ip := publicIp
+			if ip == "localhost" {
+				ip, err = gatewayIP(ingress.Gateway)
+				if err != nil {
+					return err
+				}
+			}
+			domains[0].Name, err = magicDomain(ip, magicSuffix)
+			if err != nil {
+				return err
+			}
+			tlsInputData.Domains = publicDomainNames(domains)
+		}
 
+		// The ConfigMaps are merge-patched, so the keys set with "config set" are
+		// kept
+		config := []struct {
+			name string
+			data map[string]string
+		}{
+			{"config-gc", map[string]string{"stale-revision-minimum-generations": "2"}},
+			{"config-network", map[string]string{"ingress.class": ingress.Class, "domainTemplate": strings.Trim(domainTemplate, "\"")}},
+			{"config-autoscaler", map[string]string{"enable-scale-to-zero": enableScaleToZero}},
+		}
+		for _, c := range config {
+			err = patchConfig(k8s.Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: "knative-serving", Name: c.name}, c.data)
 			if err != nil {
-				fmt.Println(fmt.Sprint(err) + ": " + string(output))
 				return err
 			}
+		}
 
-			cmd = exec.Command("kubectl", "-n", "knative-serving", "set", "env", "deployment/3scale-kourier-control", "CERTS_SECRET_NAME=tls")
-			output, err = cmd.CombinedOutput()
+		err = applyKnativeDomains(domains)
+		if err != nil {
+			return err
+		}
 
+		if len(tlsIssuer) > 0 {
+			err = applyTlsIssuer(tlsInputData)
 			if err != nil {
-				fmt.Println(fmt.Sprint(err) + ": " + string(output))
 				return err
 			}
+		}
 
+		if len(tlsIssuer) > 0 {
+			secrets := map[string]string{}
+			for _, domain := range tlsInputData.Domains {
+				secrets[domain] = wildcardCertificate(ingress.TLSNamespace, domain).Name
+			}
+			err = serveCertificates(ingressName, secrets)
+			if err != nil {
+				return err
+			}
+		} else if publicIp != "localhost" && ingressName == "kourier" {
+			// The certificate given to cicd is kept where Kourier reads it
+			err = serveCertificates(ingressName, map[string]string{domains[0].Name: tlsSecretName})
+			if err != nil {
+				return err
+			}
+		}
 
-			patch = "{\"spec\": { \"loadBalancerIP\": \"" + publicIp + "\" }}"
-
-			cmd = exec.Command("kubectl", "-n", "kourier-system", "patch", "svc", "kourier", "--patch", patch)
-			output, err = cmd.CombinedOutput()
+		if publicIp != "localhost" {
+			fmt.Fprintln(messages, publicIp)
 
+			patch := "{\"spec\": { \"loadBalancerIP\": \"" + publicIp + "\" }}"
+			err = client.Patch(context.Background(), ingress.Gateway, types.StrategicMergePatchType, []byte(patch))
 			if err != nil {
-				fmt.Println(fmt.Sprint(err) + ": " + string(output))
 				return err
 			}
 		}
 
-		inputData2 := KnativeServingConfigMapInputData{
-			DomainTemplate:    domainTemplate,
-			Domain:        domain,
-			EnableScaleToZero: enableScaleToZero,
+		if wait, _ := command.Flags().GetBool("wait"); wait && len(tlsIssuer) > 0 && !isDryRun() {
+			ctx, cancel := context.WithTimeout(context.Background(), certificateTimeout)
+			defer cancel()
+			for _, domain := range tlsInputData.Domains {
+				fmt.Fprintf(messages, "Waiting for the certificate of *.%s\n", domain)
+				err = client.Wait(ctx, wildcardCertificate(tlsInputData.SecretNamespace, domain), k8s.ConditionTrue("Ready"))
+				if err != nil {
+					return err
+				}
+			}
 		}
 
-		err2 := buildApplyYAML(inputData2, knativeServingConfigMapYamlTemplate, "temp_knative_serving_cm.yaml")
-		if err2 != nil {
-			return err2
+		err = recordVersion("knative-serving", release.Version)
+		if err != nil {
+			return err
 		}
 
-		fmt.Println(KnativeServingInstallMsg)
+		err = recordVersion(knativeServingIngressKey, ingressName)
+		if err != nil {
+			return err
+		}
+
+		endpoints, err := domainEndpoints(domains, domainTemplate, publicIp, len(tlsIssuer) > 0)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, KnativeServingInstallMsg)
+		fmt.Fprint(messages, endpoints)
 
 		return nil
 	}
@@ -144,6 +285,281 @@ func MakeInstallKnativeServing() *cobra.Command {
 	return knativeServing
 }
 
+func MakeUninstallKnativeServing() *cobra.Command {
+	var knativeServing = &cobra.Command{
+		Use:          "knative-serving",
+		Short:        "Uninstall knative-serving",
+		Long:         `Uninstall knative-serving and its networking layer, the gateways of Contour and Istio are kept`,
+		Example:      `  coolknative uninstall knative-serving`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(knativeServing, "knative-serving")
+
+	knativeServing.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "knative-serving")
+		if err != nil {
+			return err
+		}
+
+		installed, err := getInstalledVersions()
+		if err != nil {
+			return err
+		}
+		ingressName := installed[knativeServingIngressKey]
+		if len(ingressName) == 0 {
+			ingressName = defaultKnativeIngress
+		}
+		ingress, err := getKnativeIngress(ingressName)
+		if err != nil {
+			return err
+		}
+
+		err = deleteManifests("", release.Version, append(knativeServingManifests(release), ingress.Manifests(release)...), keepCRDs)
+		if err != nil {
+			return err
+		}
+
+		err = deleteTlsIssuers(ingress)
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion("knative-serving")
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion(knativeServingIngressKey)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Knative serving has been uninstalled.")
+
+		return nil
+	}
+
+	return knativeServing
+}
+
+var knativeServingReadiness = componentHealth{
+	Name: "knative-serving",
+	Checks: []readinessCheck{
+		deploymentAvailable("knative-serving", "activator"),
+		deploymentAvailable("knative-serving", "autoscaler"),
+		deploymentAvailable("knative-serving", "autoscaler-hpa"),
+		deploymentAvailable("knative-serving", "controller"),
+		deploymentAvailable("knative-serving", "webhook"),
+	},
+	VersionLabel: "serving.knative.dev/release",
+}
+
+// wildcardCertificatePrefix names the certificates of --tls-issuer, after the
+// domain they are issued for. Their Secrets have the same name.
+const wildcardCertificatePrefix = "knative-wildcard."
+
+// wildcardCertificate is issued for a --domain which is not internal with
+// --tls-issuer, in the namespace where the gateway reads it.
+func wildcardCertificate(namespace, domain string) k8s.Object {
+	return k8s.Object{APIVersion: "cert-manager.io/v1", Kind: "Certificate", Namespace: namespace, Name: wildcardCertificatePrefix + domain}
+}
+
+// deleteWildcardCertificates removes the certificates of --tls-issuer but the
+// ones of the given domains.
+func deleteWildcardCertificates(namespace string, keep []string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	certificates, err := client.List(context.Background(), "cert-manager.io/v1", "Certificate", namespace, metav1.ListOptions{})
+	if meta.IsNoMatchError(err) {
+		// Without cert-manager there is no certificate
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	kept := map[string]bool{}
+	for _, domain := range keep {
+		kept[wildcardCertificate(namespace, domain).Name] = true
+	}
+	for _, certificate := range certificates {
+		name := certificate.GetName()
+		// knative-wildcard was the certificate of all the domains
+		if kept[name] || (name != "knative-wildcard" && !strings.HasPrefix(name, wildcardCertificatePrefix)) {
+			continue
+		}
+		err = client.DeleteObject(context.Background(), k8s.Object{APIVersion: "cert-manager.io/v1", Kind: "Certificate", Namespace: namespace, Name: name})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTlsInputData reads the flags of --tls-issuer.
+func getTlsInputData(command *cobra.Command, domains []string) (KnativeServingTlsInputData, error) {
+	tlsIssuer, _ := command.Flags().GetString("tls-issuer")
+	caSecret, _ := command.Flags().GetString("ca-secret")
+	acmeEmail, _ := command.Flags().GetString("acme-email")
+	acmeServer, _ := command.Flags().GetString("acme-server")
+	acmeSolvers, _ := command.Flags().GetString("acme-solvers")
+
+	inputData := KnativeServingTlsInputData{
+		Domains:    domains,
+		Issuer:     "coolknative-" + tlsIssuer,
+		CASecret:   caSecret,
+		AcmeEmail:  acmeEmail,
+		AcmeServer: acmeServer,
+	}
+
+	switch tlsIssuer {
+	case "", tlsIssuerSelfSigned:
+	case tlsIssuerCA:
+		// A CA given explicitly is used as is, even when named like the
+		// generated one
+		if !command.Flags().Changed("ca-secret") || len(inputData.CASecret) == 0 {
+			inputData.CASecret = generatedCASecret
+			inputData.GenerateCA = true
+		}
+	case tlsIssuerAcme:
+		if len(acmeEmail) == 0 || len(acmeSolvers) == 0 {
+			return inputData, fmt.Errorf("--tls-issuer acme requires --acme-email and --acme-solvers")
+		}
+		data, err := ioutil.ReadFile(acmeSolvers)
+		if err != nil {
+			return inputData, err
+		}
+		solvers := []interface{}{}
+		if err := yaml.Unmarshal(data, &solvers); err != nil {
+			return inputData, fmt.Errorf("unable to read the solvers of %s: %s", acmeSolvers, err)
+		}
+		// JSON is valid YAML, so the solvers are inlined in the template
+		solversJSON, err := json.Marshal(solvers)
+		if err != nil {
+			return inputData, err
+		}
+		inputData.AcmeSolvers = string(solversJSON)
+	default:
+		return inputData, fmt.Errorf("--tls-issuer requires selfsigned, ca or acme, got %q", tlsIssuer)
+	}
+	return inputData, nil
+}
+
+// certManagerWebhookTimeout bounds the wait for the webhook of cert-manager to
+// accept the issuers.
+const certManagerWebhookTimeout = 5 * time.Minute
+
+// applyTlsIssuer creates the ClusterIssuer of --tls-issuer and the wildcard
+// certificate, cert-manager then renews it in its secret.
+func applyTlsIssuer(inputData KnativeServingTlsInputData) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := buildYAML(inputData, knativeServingTlsYamlTemplate)
+	if err != nil {
+		return err
+	}
+
+	if isDryRun() {
+		return client.Apply(context.Background(), manifest, "")
+	}
+
+	// The issuers are validated by the webhook of cert-manager
+	webhook := k8s.Object{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "cert-manager", Name: "cert-manager-webhook"}
+	_, err = client.Get(context.Background(), webhook)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("--tls-issuer requires cert-manager, install it with \"coolknative install cert-manager\"")
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), certManagerWebhookTimeout)
+	defer cancel()
+	err = client.Wait(ctx, webhook, k8s.ConditionTrue("Available"))
+	if err != nil {
+		return err
+	}
+
+	// The webhook still fails the calls for a while once available, until
+	// cert-manager has injected its CA
+	delay := time.Second
+	for {
+		err = client.Apply(ctx, manifest, "")
+		if err == nil {
+			// The certificates of the domains no longer given are not renewed
+			// anymore
+			return deleteWildcardCertificates(inputData.SecretNamespace, inputData.Domains)
+		}
+		if !apierrors.IsInternalError(err) && !apierrors.IsServiceUnavailable(err) && !apierrors.IsTimeout(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the webhook of cert-manager did not accept the issuers within %s: %s", certManagerWebhookTimeout, err)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// deleteTlsIssuers removes the ClusterIssuers of --tls-issuer and the wildcard
+// certificates.
+func deleteTlsIssuers(ingress knativeIngress) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	if len(ingress.TLSNamespace) > 0 {
+		err = deleteWildcardCertificates(ingress.TLSNamespace, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, issuer := range []string{tlsIssuerSelfSigned, tlsIssuerCA, tlsIssuerAcme} {
+		err = client.DeleteObject(context.Background(), k8s.Object{
+			APIVersion: "cert-manager.io/v1",
+			Kind:       "ClusterIssuer",
+			Name:       "coolknative-" + issuer,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func knativeServingManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/knative/serving/releases/download/%s/serving-crds.yaml", release.Version),
+		fmt.Sprintf("https://github.com/knative/serving/releases/download/%s/serving-core.yaml", release.Version),
+		fmt.Sprintf("https://github.com/knative/serving/releases/download/%s/serving-hpa.yaml", release.Version),
+	}
+}
+
+// knativeServingBundleManifests are the manifests of knative-serving and of
+// every networking layer.
+func knativeServingBundleManifests(release versions.Release) []string {
+	manifests := knativeServingManifests(release)
+	for _, name := range knativeIngressNames() {
+		manifests = append(manifests, knativeIngresses[name].Manifests(release)...)
+	}
+	return manifests
+}
+
 const KnativeServingInfoMsg = `
 #
 `
@@ -154,37 +570,71 @@ const KnativeServingInstallMsg = `
 =======================================================================` +
 	"\n\n" + KnativeServingInfoMsg + "\n\n" + pkg.ThanksForUsing
 
-var knativeServingConfigMapYamlTemplate = `
-apiVersion: v1
-data:
-  {{.Domain}}: ""
-kind: ConfigMap
+var knativeServingTlsYamlTemplate = `
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
 metadata:
-  name: config-domain
-  namespace: knative-serving
-
+  name: coolknative-selfsigned
+spec:
+  selfSigned: {}
+{{- if .CASecret}}
+{{- if .GenerateCA}}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: coolknative-ca
+  namespace: cert-manager
+spec:
+  isCA: true
+  commonName: coolknative-ca
+  secretName: coolknative-ca
+  privateKey:
+    algorithm: ECDSA
+    size: 256
+  issuerRef:
+    name: coolknative-selfsigned
+    kind: ClusterIssuer
+    group: cert-manager.io
+{{- end}}
 ---
-apiVersion: v1
-data:
-  stale-revision-minimum-generations: "2"
-kind: ConfigMap
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
 metadata:
-  name: config-gc
-  namespace: knative-serving
+  name: coolknative-ca
+spec:
+  ca:
+    secretName: {{.CASecret}}
+{{- end}}
+{{- if .AcmeSolvers}}
 ---
-apiVersion: v1
-data:
-  domainTemplate: {{.DomainTemplate}}
-kind: ConfigMap
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
 metadata:
-  name: config-network
-  namespace: knative-serving
+  name: coolknative-acme
+spec:
+  acme:
+    email: {{.AcmeEmail}}
+    server: {{.AcmeServer}}
+    privateKeySecretRef:
+      name: coolknative-acme-account
+    solvers: {{.AcmeSolvers}}
+{{- end}}
+{{- range .Domains}}
 ---
-apiVersion: v1
-kind: ConfigMap
+apiVersion: cert-manager.io/v1
+kind: Certificate
 metadata:
- name: config-autoscaler
- namespace: knative-serving
-data:
- enable-scale-to-zero: "{{.EnableScaleToZero}}"
+  name: knative-wildcard.{{.}}
+  namespace: {{$.SecretNamespace}}
+spec:
+  secretName: knative-wildcard.{{.}}
+  dnsNames:
+  - "*.{{.}}"
+  - "{{.}}"
+  issuerRef:
+    name: {{$.Issuer}}
+    kind: ClusterIssuer
+    group: cert-manager.io
+{{- end}}
 `
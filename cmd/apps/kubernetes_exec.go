@@ -3,225 +3,216 @@
 package apps
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strings"
 
-	execute "github.com/alexellis/go-execute/pkg/v1"
-	"github.com/eskersoftware/coolknative/pkg/env"
+	"github.com/eskersoftware/coolknative/pkg/cache"
+	"github.com/eskersoftware/coolknative/pkg/config"
+	"github.com/eskersoftware/coolknative/pkg/helm"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
-func fetchChart(path, chart, version string, helm3 bool) error {
-	versionStr := ""
-
-	if len(version) > 0 {
-		// Issue in helm where adding a space to the command makes it think that it's another chart of " " we want to template,
-		// So we add the space before version here rather than on the command
-		versionStr = " --version " + version
-	}
-	subdir := ""
-	if helm3 {
-		subdir = "helm3"
-	}
-
-	mkErr := os.MkdirAll(path, 0700)
-
-	if mkErr != nil {
-		return mkErr
-	}
-
-	task := execute.ExecTask{
-		Command:     fmt.Sprintf("%s fetch %s --untar=true --untardir %s%s", env.LocalBinary("helm", subdir), chart, path, versionStr),
-		Env:         os.Environ(),
-		StreamStdio: true,
-	}
-	res, err := task.Execute()
-
-	if err != nil {
-		return err
-	}
-
-	if res.ExitCode != 0 {
-		return fmt.Errorf("exit code %d", res.ExitCode)
-	}
-	return nil
-}
-
-func getNodeArchitecture() string {
-	res, _ := kubectlTask("get", "nodes", `--output`, `jsonpath={range $.items[0]}{.status.nodeInfo.architecture}`)
-
-	arch := strings.TrimSpace(string(res.Stdout))
-
-	return arch
+// addChartFlags adds the flags selecting the chart of a helm-backed
+// installer, defaulting to the given chart of the given repository.
+func addChartFlags(command *cobra.Command, chart, repo string) {
+	command.Flags().String("chart", chart,
+		"Chart to install: its name in --repo, a local directory or archive starting with ./, ../, / or file://, or an oci:// reference")
+	command.Flags().String("repo", repo, "URL of the chart repository, http(s):// or file:// for a local mirror")
 }
 
-func helm3Upgrade(basePath, chart, namespace, values, version string, overrides map[string]string, wait bool) error {
+// locateChart returns the local path of the chart selected with the flags
+// added by addChartFlags, taken from the bundle when one is given. Remote
+// charts are pinned in the lock file, the version resolved on the first
+// install is kept when no version is given.
+func locateChart(command *cobra.Command, version string) (string, error) {
+	chart, _ := command.Flags().GetString("chart")
+	repo, _ := command.Flags().GetString("repo")
+	dir := path.Join(os.TempDir(), "charts")
 
-	chartName := chart
-	if index := strings.Index(chartName, "/"); index > -1 {
-		chartName = chartName[index+1:]
+	if helm.IsLocalChart(chart) {
+		return helm.LocalChartPath(chart), nil
 	}
 
-	chartRoot := basePath
-
-	args := []string{"upgrade", "--install", chartName, chart, "--namespace", namespace}
-	if len(version) > 0 {
-		args = append(args, "--version", version)
+	b, err := getBundle()
+	if err != nil {
+		return "", err
 	}
-
-	if wait {
-		args = append(args, "--wait")
+	if b != nil {
+		return extractBundledChart(b, dir, repo, chart, version)
 	}
 
-	fmt.Println("VALUES", values)
-	if len(values) > 0 {
-		args = append(args, "--values")
-		if !strings.HasPrefix(values, "/") {
-			args = append(args, path.Join(chartRoot, values))
-		} else {
-			args = append(args, values)
+	c, err := getCache()
+	if err != nil {
+		return "", err
+	}
+	key := chartKey(repo, chart, version)
+	locked, ok := c.Locked(key)
+	if ok {
+		if data, ok := c.Get(key); ok {
+			filename := path.Join(dir, fmt.Sprintf("%s-%s.tgz", path.Base(chart), locked.Version))
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return "", err
+			}
+			return filename, ioutil.WriteFile(filename, data, 0600)
 		}
+		version = locked.Version
 	}
 
-	for k, v := range overrides {
-		args = append(args, "--set")
-		args = append(args, fmt.Sprintf("%s=%s", k, v))
+	client, err := getHelmClient()
+	if err != nil {
+		return "", err
 	}
-
-	task := execute.ExecTask{
-		Command:     env.LocalBinary("helm", "helm3"),
-		Args:        args,
-		Env:         os.Environ(),
-		Cwd:         basePath,
-		StreamStdio: true,
+	filename, err := client.LocateChart(chart, repo, version, dir)
+	if err != nil {
+		return "", err
 	}
 
-	fmt.Printf("Command: %s %s\n", task.Command, task.Args)
-	res, err := task.Execute()
-
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	if res.ExitCode != 0 {
-		return fmt.Errorf("exit code %d, stderr: %s", res.ExitCode, res.Stderr)
+	resolved, err := helm.ChartVersion(filename)
+	if err != nil {
+		return "", err
 	}
-
-	if len(res.Stderr) > 0 {
-		log.Printf("stderr: %s\n", res.Stderr)
+	ref := chart
+	if len(repo) > 0 {
+		ref = strings.TrimSuffix(repo, "/") + "/" + chart
 	}
-
-	return nil
-}
-
-func templateChart(basePath, chart, namespace, outputPath, values string, overrides map[string]string) error {
-
-	rmErr := os.RemoveAll(outputPath)
-
-	if rmErr != nil {
-		log.Printf("Error cleaning up: %s, %s\n", outputPath, rmErr.Error())
+	err = c.Put(cache.Entry{Key: key, Kind: cache.KindChart, URL: ref, Version: resolved}, data)
+	if err != nil {
+		return "", err
 	}
+	return filename, nil
+}
 
-	mkErr := os.MkdirAll(outputPath, 0700)
-	if mkErr != nil {
-		return mkErr
+func getNodeArchitecture() string {
+	client, err := getKubeClient()
+	if err != nil {
+		log.Println(err)
+		return ""
 	}
 
-	overridesStr := ""
-	for k, v := range overrides {
-		overridesStr += fmt.Sprintf(" --set %s=%s", k, v)
+	nodes, err := client.List(context.Background(), "v1", "Node", "", metav1.ListOptions{Limit: 1})
+	if err != nil || len(nodes) == 0 {
+		log.Println(err)
+		return ""
 	}
 
-	chartRoot := path.Join(basePath, chart)
+	arch, _, _ := unstructured.NestedString(nodes[0].Object, "status", "nodeInfo", "architecture")
 
-	valuesStr := ""
-	if len(values) > 0 {
-		valuesStr = "--values " + path.Join(chartRoot, values)
-	}
+	return arch
+}
 
-	task := execute.ExecTask{
-		Command: fmt.Sprintf("%s template %s --name %s --namespace %s --output-dir %s %s %s",
-			env.LocalBinary("helm", ""), chart, chart, namespace, outputPath, valuesStr, overridesStr),
-		Env:         os.Environ(),
-		Cwd:         basePath,
-		StreamStdio: true,
-	}
+// addHelmValueFlags adds the flags giving values to the chart of a helm-backed
+// installer.
+func addHelmValueFlags(command *cobra.Command) {
+	command.Flags().StringArray("values", []string{}, "Local YAML file of values for the chart, can be repeated")
+	command.Flags().StringArray("set", []string{},
+		"Set a value of the chart, can be repeated \n(example --set persistence.enabled=true)")
+	command.Flags().StringArray("set-string", []string{}, "Set a value of the chart as a string, can be repeated")
+	command.Flags().StringArray("set-file", []string{}, "Set a value of the chart from the content of a file, as key=path, can be repeated")
+	command.Flags().Bool("reset-values", false, "Ignore the values stored with the installed release instead of merging the given ones over them")
+}
 
-	res, err := task.Execute()
+// getHelmValues returns the values given to the chart with the flags added by
+// addHelmValueFlags.
+func getHelmValues(command *cobra.Command) helm.ValueOptions {
+	var values helm.ValueOptions
+	values.ValueFiles, _ = command.Flags().GetStringArray("values")
+	values.Values, _ = command.Flags().GetStringArray("set")
+	values.StringValues, _ = command.Flags().GetStringArray("set-string")
+	values.FileValues, _ = command.Flags().GetStringArray("set-file")
+	return values
+}
 
+// helm3Upgrade installs or upgrades a release of a local chart, a directory
+// or an archive. The values are stored with the release and merged with the
+// ones given on the next upgrade, unless resetValues is set.
+func helm3Upgrade(chart, releaseName, namespace string, options helm.ValueOptions, resetValues, wait bool) error {
+	client, err := getHelmClient()
 	if err != nil {
 		return err
 	}
 
-	if res.ExitCode != 0 {
-		return fmt.Errorf("exit code %d, stderr: %s", res.ExitCode, res.Stderr)
+	values, err := options.Merge()
+	if err != nil {
+		return err
 	}
 
-	if len(res.Stderr) > 0 {
-		log.Printf("stderr: %s\n", res.Stderr)
+	rel, err := client.Upgrade(releaseName, chart, namespace, values, resetValues, wait)
+	if err != nil {
+		return err
 	}
 
+	fmt.Fprintf(messages, "Release %s revision %d of chart %s-%s: %s\n", rel.Name, rel.Version,
+		rel.Chart.Metadata.Name, rel.Chart.Metadata.Version, rel.Info.Status)
 	return nil
 }
 
-func addHelmRepo(name, url string, helm3 bool) error {
-	subdir := ""
-	if helm3 {
-		subdir = "helm3"
-	}
-
-	task := execute.ExecTask{
-		Command:     fmt.Sprintf("%s repo add %s %s", env.LocalBinary("helm", subdir), name, url),
-		Env:         os.Environ(),
-		StreamStdio: true,
-	}
-	res, err := task.Execute()
-
+func helm3Uninstall(releaseName, namespace string) error {
+	client, err := getHelmClient()
 	if err != nil {
 		return err
 	}
-
-	if res.ExitCode != 0 {
-		return fmt.Errorf("exit code %d", res.ExitCode)
-	}
-	return nil
+	return client.Uninstall(releaseName, namespace)
 }
 
-func updateHelmRepos(helm3 bool) error {
-	subdir := ""
-	if helm3 {
-		subdir = "helm3"
-	}
-	task := execute.ExecTask{
-		Command:     fmt.Sprintf("%s repo update", env.LocalBinary("helm", subdir)),
-		Env:         os.Environ(),
-		StreamStdio: true,
+// templateChart renders a local chart like helm3Upgrade would install it,
+// without contacting the cluster, so only with the given values.
+func templateChart(chart, releaseName, namespace string, options helm.ValueOptions) ([]byte, error) {
+	client, err := getHelmClient()
+	if err != nil {
+		return nil, err
 	}
 
-	res, err := task.Execute()
-
+	values, err := options.Merge()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if res.ExitCode != 0 {
-		return fmt.Errorf("exit code %d", res.ExitCode)
-	}
-	return nil
+	return client.Template(releaseName, chart, namespace, values)
 }
 
-func kubectlTask(parts ...string) (execute.ExecResult, error) {
-	task := execute.ExecTask{
-		Command:     "kubectl",
-		Args:        parts,
-		StreamStdio: false,
+// kubeconfig and kubeContext are set from the flags of the running command by
+// useDefaultKubeconfig, and used for every cluster and helm call.
+var kubeconfig, kubeContext string
+
+// kubeClient is created on first use for the selected kubeconfig and context.
+var kubeClient k8s.Client
+
+func getKubeClient() (k8s.Client, error) {
+	if kubeClient == nil {
+		client, err := k8s.New(kubeconfig, kubeContext)
+		if err != nil {
+			return nil, err
+		}
+		kubeClient = client
 	}
+	return kubeClient, nil
+}
 
-	res, err := task.Execute()
+// helmClient is created on first use for the selected kubeconfig and context,
+// it keeps its chart repositories in the user dir.
+var helmClient *helm.Client
 
-	return res, err
+func getHelmClient() (*helm.Client, error) {
+	if helmClient == nil {
+		userPath, err := config.InitUserDir()
+		if err != nil {
+			return nil, err
+		}
+		helmClient = helm.New(kubeconfig, kubeContext, path.Join(userPath, ".helm"))
+	}
+	return helmClient, nil
 }
 
 func getDefaultKubeconfig() string {
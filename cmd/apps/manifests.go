@@ -0,0 +1,241 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/eskersoftware/coolknative/pkg/cache"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// applyManifests applies the remote manifests of a release in order, in the
+// given namespace when it is not empty.
+func applyManifests(namespace, version string, urls []string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		manifest, err := fetchManifest(url, version)
+		if err != nil {
+			return err
+		}
+		err = client.Apply(context.Background(), manifest, namespace)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteManifests deletes the remote manifests of a release in the reverse
+// order of their installation. CustomResourceDefinitions are left in place when
+// keepCRDs is set.
+func deleteManifests(namespace, version string, urls []string, keepCRDs bool) error {
+	for i := len(urls) - 1; i >= 0; i-- {
+		manifest, err := fetchManifest(urls[i], version)
+		if err != nil {
+			return err
+		}
+		err = deleteYAML(namespace, manifest, keepCRDs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchManifest reads a remote manifest from the bundle given with --bundle,
+// or from the cache when it is pinned, or downloads it.
+func fetchManifest(url, version string) ([]byte, error) {
+	b, err := getBundle()
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		return b.Get(url)
+	}
+
+	c, err := getCache()
+	if err != nil {
+		return nil, err
+	}
+	entry := cache.Entry{Key: url, Kind: cache.KindManifest, URL: url, Version: version}
+	return c.Fetch(entry, func() ([]byte, error) {
+		return download(url)
+	})
+}
+
+// buildKustomization renders a kustomization like "kubectl apply -k", remote
+// targets are cloned with git. With --bundle, the rendered kustomization is
+// read from the bundle. The rendered kustomization is pinned like a manifest,
+// so a moved tag is detected.
+func buildKustomization(target, version string) ([]byte, error) {
+	b, err := getBundle()
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		return b.Get(kustomizationKey(target))
+	}
+
+	c, err := getCache()
+	if err != nil {
+		return nil, err
+	}
+	entry := cache.Entry{Key: kustomizationKey(target), Kind: cache.KindManifest, URL: target, Version: version}
+	return c.Fetch(entry, func() ([]byte, error) {
+		resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), target)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build kustomization %s: %s", target, err)
+		}
+		return resources.AsYaml()
+	})
+}
+
+// deleteYAML deletes the resources of a multi-document YAML manifest, ignoring
+// the ones which are already gone.
+func deleteYAML(namespace string, manifest []byte, keepCRDs bool) error {
+	if keepCRDs {
+		manifest = withoutKind(manifest, "CustomResourceDefinition")
+	}
+	if len(bytes.TrimSpace(manifest)) == 0 {
+		return nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	deleted, err := client.Delete(context.Background(), manifest, namespace)
+	for _, obj := range deleted {
+		fmt.Fprintf(messages, "%s deleted\n", obj)
+	}
+	return err
+}
+
+// withoutKind removes the documents of the given kind from a multi-document
+// YAML manifest.
+func withoutKind(manifest []byte, kind string) []byte {
+	var kept [][]byte
+	for _, document := range documentSeparator.Split(string(manifest), -1) {
+		meta := struct {
+			Kind string `json:"kind"`
+		}{}
+		if err := yaml.Unmarshal([]byte(document), &meta); err == nil && meta.Kind == kind {
+			continue
+		}
+		kept = append(kept, []byte(document))
+	}
+	return bytes.Join(kept, []byte("\n---\n"))
+}
+
+// createNamespace creates a namespace when it does not exist yet.
+func createNamespace(namespace string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	manifest := fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", namespace)
+	return client.Apply(context.Background(), []byte(manifest), "")
+}
+
+// setDeploymentEnv sets an environment variable on every container of a
+// deployment, like "kubectl set env".
+func setDeploymentEnv(namespace, deployment, name, value string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	obj := k8s.Object{APIVersion: "apps/v1", Kind: "Deployment", Namespace: namespace, Name: deployment}
+	res, err := client.Get(context.Background(), obj)
+	if err != nil {
+		return err
+	}
+
+	containers, _, _ := unstructured.NestedSlice(res.Object, "spec", "template", "spec", "containers")
+	patchContainers := []map[string]interface{}{}
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		patchContainers = append(patchContainers, map[string]interface{}{
+			"name": container["name"],
+			"env":  []map[string]string{{"name": name, "value": value}},
+		})
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": patchContainers,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.Patch(context.Background(), obj, types.StrategicMergePatchType, patch)
+}
+
+// deletePVCs removes the PersistentVolumeClaims matching a label selector,
+// unless keepPVCs is set.
+func deletePVCs(namespace, selector string, keepPVCs bool) error {
+	if keepPVCs {
+		fmt.Fprintf(messages, "Keeping PersistentVolumeClaims %s in %s\n", selector, namespace)
+		return nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	return client.DeleteCollection(context.Background(), "v1", "PersistentVolumeClaim", namespace, metav1.ListOptions{LabelSelector: selector})
+}
+
+// deleteCRDs removes CustomResourceDefinitions registered at runtime by an
+// operator rather than shipped in its manifests.
+func deleteCRDs(names []string, keepCRDs bool) error {
+	if keepCRDs {
+		return nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = client.DeleteObject(context.Background(), k8s.Object{
+			APIVersion: "apiextensions.k8s.io/v1",
+			Kind:       "CustomResourceDefinition",
+			Name:       name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	b64 "encoding/base64"
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
 	"github.com/spf13/cobra"
 )
 
@@ -49,7 +50,52 @@ func MakeInstallMinioInstance() *cobra.Command {
 			return err
 		}
 
-		fmt.Println(MinioInstanceInstallMsg)
+		err = recordVersion("minio-instance", "")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, MinioInstanceInstallMsg)
+
+		return nil
+	}
+
+	return minioInstance
+}
+
+func MakeUninstallMinioInstance() *cobra.Command {
+	var minioInstance = &cobra.Command{
+		Use:          "minio-instance",
+		Short:        "Uninstall minio-instance",
+		Long:         `Uninstall the minio Tenant and, unless --keep-pvcs is set, its volumes`,
+		Example:      `  coolknative uninstall minio-instance --namespace minio`,
+		SilenceUsage: true,
+	}
+
+	minioInstance.Flags().StringP("namespace", "n", "minio", "Minio instance install namespace")
+
+	minioInstance.RunE = func(command *cobra.Command, args []string) error {
+		namespace, _ := command.Flags().GetString("namespace")
+
+		useDefaultKubeconfig(command)
+		_, keepPVCs := getUninstallFlags(command)
+
+		err := buildDeleteYAML(MinioInstanceInputData{Namespace: namespace}, minioInstanceYamlTemplate)
+		if err != nil {
+			return err
+		}
+
+		err = deletePVCs(namespace, "v1.min.io/tenant=minio", keepPVCs)
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion("minio-instance")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Minio Instance has been uninstalled.")
 
 		return nil
 	}
@@ -66,6 +112,17 @@ const MinioInstanceInstallMsg = `
 =======================================================================` +
 	"\n\n" + MinioInstanceInfoMsg + "\n\n" + pkg.ThanksForUsing
 
+var minioInstanceReadiness = componentHealth{
+	Name: "minio-instance",
+	Checks: []readinessCheck{
+		{
+			Object: k8s.Object{APIVersion: "minio.min.io/v1", Kind: "Tenant", Name: "minio"},
+			Ready:  fieldEquals("Initialized", "status", "currentState"),
+		},
+		statefulSetsReady("", "v1.min.io/tenant=minio"),
+	},
+}
+
 var minioInstanceYamlTemplate = `
 apiVersion: minio.min.io/v1
 kind: Tenant
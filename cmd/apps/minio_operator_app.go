@@ -3,6 +3,7 @@
 package apps
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/eskersoftware/coolknative/pkg"
@@ -12,27 +13,93 @@ import (
 
 func MakeInstallMinioOperator() *cobra.Command {
 	var minioOperator = &cobra.Command{
-		Use:          "minio-operator",
-		Short:        "Install minio-operator",
-		Long:         `Install minio-operator`,
-		Example:      `  coolknative install minio-operator`,
+		Use:   "minio-operator",
+		Short: "Install minio-operator",
+		Long:  `Install minio-operator`,
+		Example: `  coolknative install minio-operator
+  coolknative install minio-operator --version v3.0.29`,
 		SilenceUsage: true,
 	}
 
+	addVersionFlags(minioOperator, "minio-operator")
+
 	minioOperator.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
 
+		release, err := getRelease(command, "minio-operator")
+		if err != nil {
+			return err
+		}
+		version := release.Version
 
 		arch := getNodeArchitecture()
-		fmt.Printf("Node architecture: %q\n", arch)
+		fmt.Fprintf(messages, "Node architecture: %q\n", arch)
+
+		manifest, err := buildKustomization(minioOperatorKustomization(version), version)
+		if err != nil {
+			return err
+		}
+
+		client, err := getKubeClient()
+		if err != nil {
+			return err
+		}
+
+		err = client.Apply(context.Background(), manifest, "")
+		if err != nil {
+			return err
+		}
+
+		err = recordVersion("minio-operator", version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, MinioOperatorInstallMsg)
+
+		return nil
+	}
+
+	return minioOperator
+}
+
+func MakeUninstallMinioOperator() *cobra.Command {
+	var minioOperator = &cobra.Command{
+		Use:          "minio-operator",
+		Short:        "Uninstall minio-operator",
+		Long:         `Uninstall minio-operator`,
+		Example:      `  coolknative uninstall minio-operator`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(minioOperator, "minio-operator")
 
-		_, err := kubectlTask("apply", "-k",
-			"github.com/minio/operator")
+	minioOperator.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "minio-operator")
 		if err != nil {
 			return err
 		}
+		version := release.Version
 
-		fmt.Println(MinioOperatorInstallMsg)
+		manifest, err := buildKustomization(minioOperatorKustomization(version), version)
+		if err != nil {
+			return err
+		}
+
+		err = deleteYAML("", manifest, keepCRDs)
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion("minio-operator")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Minio Operator has been uninstalled.")
 
 		return nil
 	}
@@ -40,6 +107,20 @@ func MakeInstallMinioOperator() *cobra.Command {
 	return minioOperator
 }
 
+var minioOperatorReadiness = componentHealth{
+	Name:   "minio-operator",
+	Checks: []readinessCheck{deploymentAvailable("minio-operator", "minio-operator")},
+}
+
+// minioOperatorKustomization returns the kustomization of the minio operator
+// at a git tag, or on its default branch.
+func minioOperatorKustomization(version string) string {
+	if len(version) == 0 {
+		return "github.com/minio/operator"
+	}
+	return "github.com/minio/operator?ref=" + version
+}
+
 const MinioOperatorInfoMsg = `
 #`
 
@@ -5,6 +5,7 @@ package apps
 import (
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/versions"
 	"github.com/spf13/cobra"
 )
 
@@ -17,22 +18,70 @@ func MakeInstallNatsOperator() *cobra.Command {
 		SilenceUsage: true,
 	}
 
+	addVersionFlags(natsOperator, "nats-operator")
+
+	natsOperator.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		release, err := getRelease(command, "nats-operator")
+		if err != nil {
+			return err
+		}
+
+		err = applyManifests("", release.Version, natsOperatorManifests(release))
+		if err != nil {
+			return err
+		}
+
+		err = recordVersion("nats-operator", release.Version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, NatsOperatorInstallMsg)
+
+		return nil
+	}
+
+	return natsOperator
+}
+
+func MakeUninstallNatsOperator() *cobra.Command {
+	var natsOperator = &cobra.Command{
+		Use:          "nats-operator",
+		Short:        "Uninstall nats-operator",
+		Long:         `Uninstall nats-operator`,
+		Example:      `  coolknative uninstall nats-operator`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(natsOperator, "nats-operator")
+
 	natsOperator.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "nats-operator")
+		if err != nil {
+			return err
+		}
 
-		_, err := kubectlTask("apply", "-f",
-			"https://github.com/nats-io/nats-operator/releases/download/v0.7.2/00-prereqs.yaml")
+		err = deleteManifests("", release.Version, natsOperatorManifests(release), keepCRDs)
 		if err != nil {
 			return err
 		}
 
-		_, err = kubectlTask("apply", "-f",
-			"https://github.com/nats-io/nats-operator/releases/download/v0.7.2/10-deployment.yaml")
+		err = deleteCRDs(natsOperatorCRDs, keepCRDs)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(NatsOperatorInstallMsg)
+		err = forgetVersion("nats-operator")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Nats operator has been uninstalled.")
 
 		return nil
 	}
@@ -40,6 +89,24 @@ func MakeInstallNatsOperator() *cobra.Command {
 	return natsOperator
 }
 
+func natsOperatorManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/nats-io/nats-operator/releases/download/%s/00-prereqs.yaml", release.Version),
+		fmt.Sprintf("https://github.com/nats-io/nats-operator/releases/download/%s/10-deployment.yaml", release.Version),
+	}
+}
+
+var natsOperatorReadiness = componentHealth{
+	Name:   "nats-operator",
+	Checks: []readinessCheck{deploymentAvailable("default", "nats-operator")},
+}
+
+// natsOperatorCRDs are registered by the operator when it starts.
+var natsOperatorCRDs = []string{
+	"natsclusters.nats.io",
+	"natsserviceroles.nats.io",
+}
+
 const NatsOperatorInfoMsg = `
 # 
 `
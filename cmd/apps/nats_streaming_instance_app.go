@@ -5,7 +5,9 @@ package apps
 import (
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type NatsStreamingInstanceInputData struct {
@@ -25,7 +27,7 @@ func MakeInstallNatsStreamingInstance() *cobra.Command {
 		useDefaultKubeconfig(command)
 
 		arch := getNodeArchitecture()
-		fmt.Printf("Node architecture: %q\n", arch)
+		fmt.Fprintf(messages, "Node architecture: %q\n", arch)
 
 		inputData := NatsStreamingInstanceInputData{
 			Size: "3",
@@ -36,7 +38,12 @@ func MakeInstallNatsStreamingInstance() *cobra.Command {
 			return err
 		}
 
-		fmt.Println(NatsStreamingInstanceInstallMsg)
+		err = recordVersion("nats-streaming-instance", "")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, NatsStreamingInstanceInstallMsg)
 
 		return nil
 	}
@@ -44,6 +51,36 @@ func MakeInstallNatsStreamingInstance() *cobra.Command {
 	return minioInstance
 }
 
+func MakeUninstallNatsStreamingInstance() *cobra.Command {
+	var natsStreamingInstance = &cobra.Command{
+		Use:          "nats-streaming-instance",
+		Short:        "Uninstall nats-streaming-instance",
+		Long:         `Uninstall the NatsStreamingCluster and NatsCluster`,
+		Example:      `  coolknative uninstall nats-streaming-instance`,
+		SilenceUsage: true,
+	}
+
+	natsStreamingInstance.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		err := buildDeleteYAML(NatsStreamingInstanceInputData{}, natsStreamingInstanceYamlTemplate)
+		if err != nil {
+			return err
+		}
+
+		err = forgetVersion("nats-streaming-instance")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Nats Streaming Instance has been uninstalled.")
+
+		return nil
+	}
+
+	return natsStreamingInstance
+}
+
 const NatsStreamingInstanceInfoMsg = `
 #`
 
@@ -53,6 +90,24 @@ const NatsStreamingInstanceInstallMsg = `
 =======================================================================` +
 	"\n\n" + NatsStreamingInstanceInfoMsg + "\n\n" + pkg.ThanksForUsing
 
+var natsStreamingInstanceReadiness = componentHealth{
+	Name: "nats-streaming-instance",
+	Checks: []readinessCheck{
+		{
+			Object: k8s.Object{APIVersion: "nats.io/v1alpha2", Kind: "NatsCluster", Namespace: "default", Name: "nats"},
+			Ready:  allReady(k8s.ConditionTrue("available"), sizeReached),
+		},
+		{
+			// The streaming operator keeps no status, its pods are counted
+			Object: k8s.Object{APIVersion: "streaming.nats.io/v1alpha1", Kind: "NatsStreamingCluster", Namespace: "default", Name: "nats-streaming"},
+			Ready:  exists,
+			Pods: func(obj *unstructured.Unstructured) (string, int64) {
+				return "stan_cluster=" + obj.GetName(), specSize(obj)
+			},
+		},
+	},
+}
+
 var natsStreamingInstanceYamlTemplate = `
 apiVersion: "streaming.nats.io/v1alpha1"
 kind: "NatsStreamingCluster"
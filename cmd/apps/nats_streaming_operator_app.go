@@ -5,6 +5,7 @@ package apps
 import (
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/versions"
 	"github.com/spf13/cobra"
 )
 
@@ -17,22 +18,70 @@ func MakeInstallNatsStreamingOperator() *cobra.Command {
 		SilenceUsage: true,
 	}
 
+	addVersionFlags(natsStreamingOperator, "nats-streaming-operator")
+
+	natsStreamingOperator.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		release, err := getRelease(command, "nats-streaming-operator")
+		if err != nil {
+			return err
+		}
+
+		err = applyManifests("default", release.Version, natsStreamingOperatorManifests(release))
+		if err != nil {
+			return err
+		}
+
+		err = recordVersion("nats-streaming-operator", release.Version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, NatsStreamingOperatorInstallMsg)
+
+		return nil
+	}
+
+	return natsStreamingOperator
+}
+
+func MakeUninstallNatsStreamingOperator() *cobra.Command {
+	var natsStreamingOperator = &cobra.Command{
+		Use:          "nats-streaming-operator",
+		Short:        "Uninstall nats-streaming-operator",
+		Long:         `Uninstall nats-streaming-operator`,
+		Example:      `  coolknative uninstall nats-streaming-operator`,
+		SilenceUsage: true,
+	}
+
+	addUninstallVersionFlag(natsStreamingOperator, "nats-streaming-operator")
+
 	natsStreamingOperator.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
+
+		release, err := getInstalledRelease(command, "nats-streaming-operator")
+		if err != nil {
+			return err
+		}
 
-		_, err := kubectlTask("apply", "-n", "default", "-f",
-			"https://github.com/nats-io/nats-streaming-operator/releases/download/v0.3.0/default-rbac.yaml")
+		err = deleteManifests("default", release.Version, natsStreamingOperatorManifests(release), keepCRDs)
 		if err != nil {
 			return err
 		}
 
-		_, err = kubectlTask("apply", "-n", "default", "-f",
-			"https://github.com/nats-io/nats-streaming-operator/releases/download/v0.3.0/deployment.yaml")
+		err = deleteCRDs(natsStreamingOperatorCRDs, keepCRDs)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(NatsStreamingOperatorInstallMsg)
+		err = forgetVersion("nats-streaming-operator")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Nats streaming operator has been uninstalled.")
 
 		return nil
 	}
@@ -40,6 +89,23 @@ func MakeInstallNatsStreamingOperator() *cobra.Command {
 	return natsStreamingOperator
 }
 
+func natsStreamingOperatorManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/nats-io/nats-streaming-operator/releases/download/%s/default-rbac.yaml", release.Version),
+		fmt.Sprintf("https://github.com/nats-io/nats-streaming-operator/releases/download/%s/deployment.yaml", release.Version),
+	}
+}
+
+var natsStreamingOperatorReadiness = componentHealth{
+	Name:   "nats-streaming-operator",
+	Checks: []readinessCheck{deploymentAvailable("default", "nats-streaming-operator")},
+}
+
+// natsStreamingOperatorCRDs are registered by the operator when it starts.
+var natsStreamingOperatorCRDs = []string{
+	"natsstreamingclusters.streaming.nats.io",
+}
+
 const NatsStreamingOperatorInfoMsg = `
 # 
 `
@@ -3,104 +3,150 @@
 package apps
 
 import (
+	"context"
 	"fmt"
 	"github.com/eskersoftware/coolknative/pkg"
-	"github.com/eskersoftware/coolknative/pkg/config"
-	"github.com/eskersoftware/coolknative/pkg/env"
-	"github.com/eskersoftware/coolknative/pkg/helm"
 	"github.com/spf13/cobra"
-	"log"
-	"os"
-	"path"
 )
 
 func MakeInstallRedis() *cobra.Command {
 	var redis = &cobra.Command{
-		Use:          "redis",
-		Short:        "Install redis",
-		Long:         `Install redis`,
-		Example:      `  coolknative install redis`,
+		Use:   "redis",
+		Short: "Install redis",
+		Long:  `Install redis`,
+		Example: `  coolknative install redis
+  coolknative install redis --version 12.0.0
+  coolknative install redis --chart oci://registry.corp.local/charts/redis --version 12.0.0
+  coolknative install redis --repo file:///mnt/charts
+  coolknative install redis --values redis-values.yaml --set-string password=s3cr=t
+  coolknative install redis --generate-secrets`,
 		SilenceUsage: true,
 	}
 
 	redis.Flags().Bool("update-repo", true, "Update the helm repo")
+	redis.Flags().MarkDeprecated("update-repo", "the index of --repo is always downloaded")
 	redis.Flags().String("namespace", "default", "Kubernetes namespace for the application")
-	redis.Flags().StringArray("set", []string{},
-		"Use custom flags or override existing flags \n(example --set persistence.enabled=true)")
+	addVersionFlags(redis, "redis")
+	addChartFlags(redis, "redis", redisChartRepo)
+	addHelmValueFlags(redis)
+	addGenerateSecretsFlag(redis)
 
 	redis.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
 
-		userPath, err := config.InitUserDir()
-		if err != nil {
-			return err
-		}
-
-		clientArch, clientOS := env.GetClientArch()
-
-		fmt.Printf("Client: %s, %s\n", clientArch, clientOS)
-		log.Printf("User dir established as: %s\n", userPath)
-
-		os.Setenv("HELM_HOME", path.Join(userPath, ".helm"))
-
 		ns, _ := redis.Flags().GetString("namespace")
-		helm3 := true
 
-		_, err = helm.TryDownloadHelm(userPath, clientArch, clientOS, helm3)
-		if err != nil {
-			return err
+		// The matrix holds the versions of the bitnami chart, another chart
+		// is installed at the version given
+		version, _ := redis.Flags().GetString("version")
+		if !command.Flags().Changed("chart") && !command.Flags().Changed("repo") {
+			release, err := getRelease(command, "redis")
+			if err != nil {
+				return err
+			}
+			version = release.Version
 		}
 
-		err = addHelmRepo("bitnami", "https://charts.bitnami.com/bitnami", helm3)
+		chart, err := locateChart(command, version)
 		if err != nil {
-			return fmt.Errorf("unable to add repo %s", err)
+			return err
 		}
 
-		updateRepo, _ := redis.Flags().GetBool("update-repo")
+		values := getHelmValues(command)
+		resetValues, _ := redis.Flags().GetBool("reset-values")
 
-		if updateRepo {
-			err = updateHelmRepos(helm3)
+		if generateSecrets, _ := redis.Flags().GetBool("generate-secrets"); generateSecrets {
+			secrets, err := getGeneratedSecrets()
 			if err != nil {
 				return err
 			}
+			// The chart takes the password as password up to version 13 and as
+			// auth.password since, the values given explicitly come after
+			values.Values = append([]string{"usePassword=true", "auth.enabled=true"}, values.Values...)
+			values.StringValues = append([]string{
+				"password=" + secrets[redisPasswordSecret],
+				"auth.password=" + secrets[redisPasswordSecret],
+			}, values.StringValues...)
 		}
 
-		chartPath := path.Join(os.TempDir(), "charts")
-		err = fetchChart(chartPath, "bitnami/redis", defaultVersion, helm3)
-
-		if err != nil {
-			return err
+		nsErr := createNamespace(ns)
+		if nsErr != nil {
+			return nsErr
 		}
 
-		overrides := map[string]string{}
+		if isDryRun() {
+			manifest, err := templateChart(chart, "redis", ns, values)
+			if err != nil {
+				return fmt.Errorf("unable to template redis chart with helm %s", err)
+			}
+			return renderer.Apply(context.Background(), manifest, ns)
+		}
 
-		customFlags, err := redis.Flags().GetStringArray("set")
+		err = helm3Upgrade(chart, "redis", ns, values, resetValues, true)
 		if err != nil {
-			return fmt.Errorf("error with --set usage: %s", err)
+			return fmt.Errorf("unable to install redis chart with helm %s", err)
 		}
 
-		if err := mergeFlags(overrides, customFlags); err != nil {
+		err = recordVersion("redis", version)
+		if err != nil {
 			return err
 		}
-		outputPath := path.Join(chartPath, "redis")
 
-		_, nsErr := kubectlTask("create", "namespace", ns)
-		if nsErr != nil {
-			return nsErr
+		fmt.Fprintln(messages, redisInstallMsg)
+		return nil
+	}
+
+	return redis
+}
+
+func MakeUninstallRedis() *cobra.Command {
+	var redis = &cobra.Command{
+		Use:          "redis",
+		Short:        "Uninstall redis",
+		Long:         `Uninstall the redis helm release and, unless --keep-pvcs is set, its volumes`,
+		Example:      `  coolknative uninstall redis --namespace redis`,
+		SilenceUsage: true,
+	}
+
+	redis.Flags().String("namespace", "default", "Kubernetes namespace for the application")
+
+	redis.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+		_, keepPVCs := getUninstallFlags(command)
+
+		ns, _ := redis.Flags().GetString("namespace")
+
+		err := helm3Uninstall("redis", ns)
+		if err != nil {
+			return fmt.Errorf("unable to uninstall redis chart with helm %s", err)
 		}
 
-		err = helm3Upgrade(outputPath, "bitnami/redis", ns, "values.yaml", defaultVersion, overrides, true)
+		// Older and newer versions of the chart label their volumes differently
+		for _, selector := range []string{"release=redis", "app.kubernetes.io/instance=redis"} {
+			err = deletePVCs(ns, selector, keepPVCs)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = forgetVersion("redis")
 		if err != nil {
-			return fmt.Errorf("unable to install redis chart with helm %s", err)
+			return err
 		}
 
-		fmt.Println(redisInstallMsg)
+		fmt.Fprintln(messages, "Redis has been uninstalled.")
 		return nil
 	}
 
 	return redis
 }
 
+var redisReadiness = componentHealth{
+	Name:        "redis",
+	Checks:      []readinessCheck{statefulSetsReady("", "app.kubernetes.io/instance=redis", "release=redis")},
+	HelmRelease: "redis",
+}
+
 var RedisInfoMsg = `# 
 `
 
@@ -0,0 +1,84 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+)
+
+// renderer replaces the cluster client in dry-run mode, it collects the
+// manifests the installer would apply.
+var renderer *k8s.Renderer
+
+// messages receives the progress messages of the installers. They go to
+// stderr when the rendered manifests are written to stdout.
+var messages io.Writer = os.Stdout
+
+func isDryRun() bool {
+	return renderer != nil
+}
+
+// startRendering switches to dry-run mode when the --dry-run flag is set.
+func startRendering(command *cobra.Command) {
+	renderer = nil
+	messages = os.Stdout
+	if dryRun, _ := command.Flags().GetBool("dry-run"); !dryRun {
+		return
+	}
+
+	renderer = k8s.NewRenderer()
+	kubeClient = renderer
+
+	if outputDir, _ := command.Flags().GetString("output-dir"); len(outputDir) == 0 {
+		messages = os.Stderr
+	}
+}
+
+// WriteRendered writes the manifests collected in dry-run mode to stdout or,
+// with --output-dir, to a file named after the app, and the release of a chart.
+func WriteRendered(command *cobra.Command) error {
+	if renderer == nil {
+		return nil
+	}
+	defer func() {
+		renderer = nil
+		kubeClient = nil
+		messages = os.Stdout
+	}()
+
+	outputDir, _ := command.Flags().GetString("output-dir")
+	if len(outputDir) == 0 {
+		return renderer.Write(os.Stdout)
+	}
+
+	err := os.MkdirAll(outputDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Several charts of a stack are told apart by their release
+	name := command.Name()
+	if release, _ := command.Flags().GetString("release-name"); len(release) > 0 {
+		name += "-" + release
+	}
+	filename := path.Join(outputDir, name+".yaml")
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = renderer.Write(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", filename)
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/sethvargo/go-password/password"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// secretsSecret is the source of truth of the credentials generated with
+// --generate-secrets, they are generated once and reused by every later
+// install.
+var secretsSecret = k8s.Object{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "coolknative-secrets"}
+
+const (
+	minioAccessKeySecret   = "minio-access-key"
+	minioSecretKeySecret   = "minio-secret-key"
+	redisPasswordSecret    = "redis-password"
+	tokenWebservice1Secret = "token-webservice-1"
+	tokenWebservice2Secret = "token-webservice-2"
+)
+
+// generatedSecrets gives the length and the number of digits of each
+// generated credential. They have no symbols, so they can be given in URLs and
+// to helm --set flags without escaping.
+var generatedSecrets = map[string][2]int{
+	minioAccessKeySecret:   {20, 5},
+	minioSecretKeySecret:   {40, 10},
+	redisPasswordSecret:    {32, 8},
+	tokenWebservice1Secret: {48, 12},
+	tokenWebservice2Secret: {48, 12},
+}
+
+// addGenerateSecretsFlag adds the flag replacing the default credentials of an
+// installer with generated ones.
+func addGenerateSecretsFlag(command *cobra.Command) {
+	command.Flags().Bool("generate-secrets", false,
+		"Use strong random credentials, generated on the first install and kept in the default/coolknative-secrets Secret")
+}
+
+// storeSecretsAttempts bounds the retries of getGeneratedSecrets when another
+// install stores generated credentials at the same time.
+const storeSecretsAttempts = 5
+
+// getGeneratedSecrets returns the generated credentials, by name. The missing
+// ones are generated and stored, the existing ones are never replaced.
+func getGeneratedSecrets() (map[string]string, error) {
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		secrets, err := storeMissingSecrets(client)
+		// Another install created or updated the Secret since it was read, its
+		// credentials are read again and kept
+		if (apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err)) && attempt < storeSecretsAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to store the generated secrets: %s", err)
+		}
+		return secrets, nil
+	}
+}
+
+// storeMissingSecrets generates the credentials missing from the Secret and
+// stores them, failing with a conflict when the Secret changed since it was
+// read.
+func storeMissingSecrets(client k8s.Client) (map[string]string, error) {
+	secrets, resourceVersion, err := readSecrets(client)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	missing := map[string]string{}
+	for name, size := range generatedSecrets {
+		if len(secrets[name]) > 0 {
+			continue
+		}
+		value, err := password.Generate(size[0], size[1], 0, false, true)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate %s: %s", name, err)
+		}
+		missing[name] = base64.StdEncoding.EncodeToString([]byte(value))
+		secrets[name] = value
+	}
+	if len(missing) == 0 {
+		return secrets, nil
+	}
+
+	if exists {
+		// The resource version makes the patch fail if the Secret changed
+		// meanwhile, rather than overwrite credentials already in use
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": resourceVersion},
+			"data":     missing,
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = client.Patch(context.Background(), secretsSecret, types.MergePatchType, patch)
+		if err != nil {
+			return nil, err
+		}
+		return secrets, nil
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": secretsSecret.APIVersion,
+		"kind":       secretsSecret.Kind,
+		"metadata":   map[string]string{"name": secretsSecret.Name, "namespace": secretsSecret.Namespace},
+		"type":       "Opaque",
+		"data":       missing,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = client.Create(context.Background(), manifest, secretsSecret.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// GetGeneratedSecrets returns the stored generated credentials, by name,
+// without generating the missing ones.
+func GetGeneratedSecrets(command *cobra.Command) (map[string]string, error) {
+	useDefaultKubeconfig(command)
+
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, _, err := readSecrets(client)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("no secrets have been generated, install with --generate-secrets first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the generated secrets: %s", err)
+	}
+	return secrets, nil
+}
+
+// SecretNames returns the names of the given credentials, sorted.
+func SecretNames(secrets map[string]string) []string {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readSecrets returns the stored generated credentials, by name, and the
+// resource version of their Secret.
+func readSecrets(client k8s.Client) (map[string]string, string, error) {
+	secrets := map[string]string{}
+
+	res, err := client.Get(context.Background(), secretsSecret)
+	if err != nil {
+		return secrets, "", err
+	}
+
+	data, _ := res.Object["data"].(map[string]interface{})
+	for name, value := range data {
+		encoded, _ := value.(string)
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return secrets, "", fmt.Errorf("invalid value of %s: %s", name, err)
+		}
+		secrets[name] = string(decoded)
+	}
+	return secrets, res.GetResourceVersion(), nil
+}
@@ -0,0 +1,404 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	StatusReady    = "ready"
+	StatusDegraded = "degraded"
+	StatusMissing  = "missing"
+)
+
+// ComponentStatus is the health of an installed component.
+type ComponentStatus struct {
+	Name    string     `json:"name"`
+	Status  string     `json:"status"`
+	Version string     `json:"version,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	Message string     `json:"message,omitempty"`
+	// Installed is set when the component is recorded as installed by
+	// coolknative, a missing component is then unhealthy.
+	Installed bool `json:"installed"`
+}
+
+// Healthy tells whether the component is ready, or missing and not expected.
+func (s ComponentStatus) Healthy() bool {
+	return s.Status == StatusReady || (s.Status == StatusMissing && !s.Installed)
+}
+
+// readinessCheck finds resources of a component and tells whether they are
+// ready.
+type readinessCheck struct {
+	// Object is looked up by name, in every namespace when Namespace is empty.
+	Object k8s.Object
+	// Selectors match the resources to check instead of Object.Name, the
+	// first selector matching resources is used.
+	Selectors []string
+	Ready     k8s.ReadyFunc
+	// Pods, when set, gives the selector and the number of the pods which must
+	// also be ready, for operators which do not report it in their status.
+	Pods func(obj *unstructured.Unstructured) (selector string, size int64)
+	// Optional checks do not degrade the component when they find nothing.
+	Optional bool
+}
+
+// componentHealth lists the checks of a component.
+type componentHealth struct {
+	Name   string
+	Checks []readinessCheck
+	// VersionLabel is a label of the checked resources giving their version.
+	VersionLabel string
+	// HelmRelease is the name of the helm release of the component.
+	HelmRelease string
+	// HelmNamespace is the namespace of the helm release, it is looked up in
+	// every namespace when empty.
+	HelmNamespace string
+}
+
+func deploymentAvailable(namespace, name string) readinessCheck {
+	return readinessCheck{
+		Object: k8s.Object{APIVersion: "apps/v1", Kind: "Deployment", Namespace: namespace, Name: name},
+		Ready:  k8s.ConditionTrue("available"),
+	}
+}
+
+func deploymentsAvailable(namespace string, selectors ...string) readinessCheck {
+	return readinessCheck{
+		Object:    k8s.Object{APIVersion: "apps/v1", Kind: "Deployment", Namespace: namespace},
+		Selectors: selectors,
+		Ready:     k8s.ConditionTrue("available"),
+	}
+}
+
+func statefulSetReady(namespace, name string) readinessCheck {
+	return readinessCheck{
+		Object: k8s.Object{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: namespace, Name: name},
+		Ready:  k8s.ReplicasReady,
+	}
+}
+
+func statefulSetsReady(namespace string, selectors ...string) readinessCheck {
+	return readinessCheck{
+		Object:    k8s.Object{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: namespace},
+		Selectors: selectors,
+		Ready:     k8s.ReplicasReady,
+	}
+}
+
+// allReady is ready when all the given functions are.
+func allReady(funcs ...k8s.ReadyFunc) k8s.ReadyFunc {
+	return func(obj *unstructured.Unstructured) (bool, error) {
+		for _, ready := range funcs {
+			ok, err := ready(obj)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// exists is ready as soon as the resource exists.
+func exists(obj *unstructured.Unstructured) (bool, error) {
+	return true, nil
+}
+
+// sizeReached is ready when the size reported in the status of a custom
+// resource reaches the size of its spec.
+func sizeReached(obj *unstructured.Unstructured) (bool, error) {
+	size, _, err := unstructured.NestedInt64(obj.Object, "spec", "size")
+	if err != nil {
+		return false, err
+	}
+	current, _, err := unstructured.NestedInt64(obj.Object, "status", "size")
+	return current >= size, err
+}
+
+// specSize returns the size of a custom resource, 1 when it is not set.
+func specSize(obj *unstructured.Unstructured) int64 {
+	size, found, _ := unstructured.NestedInt64(obj.Object, "spec", "size")
+	if !found {
+		return 1
+	}
+	return size
+}
+
+// fieldEquals is ready when a string field of the resource has the given value.
+func fieldEquals(value string, fields ...string) k8s.ReadyFunc {
+	return func(obj *unstructured.Unstructured) (bool, error) {
+		current, _, err := unstructured.NestedString(obj.Object, fields...)
+		return current == value, err
+	}
+}
+
+// healthChecks are the readiness checks declared by the installers, in
+// installation order.
+var healthChecks = []componentHealth{
+	tektonReadiness,
+	natsOperatorReadiness,
+	natsStreamingOperatorReadiness,
+	natsStreamingInstanceReadiness,
+	minioOperatorReadiness,
+	minioInstanceReadiness,
+	certManagerReadiness,
+	knativeServingReadiness,
+	knativeEventingReadiness,
+	redisReadiness,
+	lokiReadiness,
+}
+
+// lokiReadiness checks the logging stack, which is installed with arkade by the
+// cicd pipeline, so it is never recorded as installed.
+var lokiReadiness = componentHealth{
+	Name: "loki",
+	Checks: []readinessCheck{
+		deploymentAvailable("loki", "loki-stack-grafana"),
+		statefulSetReady("loki", "loki-stack"),
+	},
+}
+
+// knownComponents returns the components with declared readiness checks,
+// followed by the charts recorded as installed.
+func knownComponents(installed map[string]string) []componentHealth {
+	components := append([]componentHealth{}, healthChecks...)
+	for i, component := range components {
+		if component.Name == knativeServingReadiness.Name {
+			components[i] = knativeServingHealth(installed[knativeServingIngressKey])
+		}
+	}
+
+	charts := []string{}
+	for name := range installed {
+		if strings.HasPrefix(name, chartPrefix) {
+			charts = append(charts, name)
+		}
+	}
+	sort.Strings(charts)
+	for _, name := range charts {
+		components = append(components, chartReadiness(name))
+	}
+	return components
+}
+
+// GetStatus checks the health of every component, without waiting for them,
+// on the cluster selected by the flags of the command.
+func GetStatus(command *cobra.Command) ([]ComponentStatus, error) {
+	useDefaultKubeconfig(command)
+
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := getInstalledVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []ComponentStatus{}
+	for _, component := range knownComponents(installed) {
+		status, err := checkComponent(client, component)
+		if err != nil {
+			return nil, err
+		}
+
+		if version, ok := installed[component.Name]; ok {
+			status.Installed = true
+			if len(version) > 0 {
+				status.Version = version
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func checkComponent(client k8s.Client, component componentHealth) (ComponentStatus, error) {
+	status := ComponentStatus{Name: component.Name}
+	found := []unstructured.Unstructured{}
+	notReady := []string{}
+	missing := []string{}
+
+	helmFound := false
+	if len(component.HelmRelease) > 0 {
+		rel, err := getHelmRelease(component)
+		if err != nil {
+			return status, err
+		}
+		if rel == nil {
+			missing = append(missing, "helm release "+component.HelmRelease)
+		} else {
+			helmFound = true
+			if rel.Chart != nil && rel.Chart.Metadata != nil {
+				status.Version = rel.Chart.Metadata.Version
+			}
+			if deployed := rel.Info.FirstDeployed.Time; !deployed.IsZero() {
+				status.Since = &deployed
+			}
+			if rel.Info.Status != release.StatusDeployed {
+				notReady = append(notReady, fmt.Sprintf("helm release %s/%s is %s", rel.Namespace, rel.Name, rel.Info.Status))
+			}
+		}
+	}
+
+	for _, check := range component.Checks {
+		objects, err := findResources(client, check)
+		if err != nil {
+			return status, err
+		}
+		if len(objects) == 0 {
+			if !check.Optional {
+				missing = append(missing, describeCheck(check))
+			}
+			continue
+		}
+
+		for i := range objects {
+			ready, err := check.Ready(&objects[i])
+			if err != nil {
+				return status, err
+			}
+			if !ready {
+				notReady = append(notReady, fmt.Sprintf("%s is not ready", describeObject(objects[i])))
+				continue
+			}
+
+			if check.Pods != nil {
+				message, err := checkPods(client, objects[i], check)
+				if err != nil {
+					return status, err
+				}
+				if len(message) > 0 {
+					notReady = append(notReady, message)
+				}
+			}
+		}
+		found = append(found, objects...)
+	}
+
+	switch {
+	case len(found) == 0 && !helmFound:
+		status.Status = StatusMissing
+		return status, nil
+	case len(notReady) > 0:
+		status.Status = StatusDegraded
+		status.Message = notReady[0]
+	case len(missing) > 0:
+		status.Status = StatusDegraded
+		status.Message = fmt.Sprintf("%s is missing", missing[0])
+	default:
+		status.Status = StatusReady
+	}
+
+	for _, obj := range found {
+		created := obj.GetCreationTimestamp().Time
+		if !created.IsZero() && (status.Since == nil || created.Before(*status.Since)) {
+			status.Since = &created
+		}
+		if len(status.Version) == 0 && len(component.VersionLabel) > 0 {
+			status.Version = obj.GetLabels()[component.VersionLabel]
+		}
+	}
+
+	return status, nil
+}
+
+// checkPods returns why the pods of a resource are not ready, nothing when
+// they are.
+func checkPods(client k8s.Client, obj unstructured.Unstructured, check readinessCheck) (string, error) {
+	selector, size := check.Pods(&obj)
+
+	pods, err := client.List(context.Background(), "v1", "Pod", obj.GetNamespace(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", err
+	}
+
+	ready := int64(0)
+	for i := range pods {
+		if ok, _ := k8s.ConditionTrue("ready")(&pods[i]); ok {
+			ready++
+		}
+	}
+
+	if ready < size {
+		return fmt.Sprintf("%s has %d/%d ready pods", describeObject(obj), ready, size), nil
+	}
+	return "", nil
+}
+
+// findResources returns the resources matched by a check, none when they are
+// missing or their kind is not installed.
+func findResources(client k8s.Client, check readinessCheck) ([]unstructured.Unstructured, error) {
+	obj := check.Object
+
+	if len(check.Selectors) > 0 {
+		for _, selector := range check.Selectors {
+			items, err := client.List(context.Background(), obj.APIVersion, obj.Kind, obj.Namespace, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, ignoreMissing(err)
+			}
+			if len(items) > 0 {
+				return items, nil
+			}
+		}
+		return nil, nil
+	}
+
+	if len(obj.Namespace) == 0 {
+		items, err := client.List(context.Background(), obj.APIVersion, obj.Kind, "", metav1.ListOptions{FieldSelector: "metadata.name=" + obj.Name})
+		return items, ignoreMissing(err)
+	}
+
+	res, err := client.Get(context.Background(), obj)
+	if err != nil {
+		return nil, ignoreMissing(err)
+	}
+	return []unstructured.Unstructured{*res}, nil
+}
+
+// ignoreMissing ignores the errors of resources or kinds which do not exist.
+func ignoreMissing(err error) error {
+	if err == nil || apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	return err
+}
+
+func describeCheck(check readinessCheck) string {
+	if len(check.Selectors) > 0 {
+		return fmt.Sprintf("%s %s", check.Object.Kind, check.Selectors[0])
+	}
+	return check.Object.String()
+}
+
+func describeObject(obj unstructured.Unstructured) string {
+	if len(obj.GetNamespace()) == 0 {
+		return fmt.Sprintf("%s %s", obj.GetKind(), obj.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
+
+// getHelmRelease returns the latest revision of the helm release of a
+// component, nil when it is not installed.
+func getHelmRelease(component componentHealth) (*release.Release, error) {
+	client, err := getHelmClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Release(component.HelmRelease, component.HelmNamespace)
+}
@@ -6,35 +6,80 @@ import (
 	"fmt"
 
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/versions"
 
 	"github.com/spf13/cobra"
 )
 
 func MakeInstallTekton() *cobra.Command {
+	var tekton = &cobra.Command{
+		Use:   "tekton",
+		Short: "Install tekton",
+		Long:  `Install tekton`,
+		Example: `  coolknative install tekton
+  coolknative install tekton --version v0.15.2`,
+		SilenceUsage: true,
+	}
+
+	addVersionFlags(tekton, "tekton")
+
+	tekton.RunE = func(command *cobra.Command, args []string) error {
+		useDefaultKubeconfig(command)
+
+		release, err := getRelease(command, "tekton")
+		if err != nil {
+			return err
+		}
+
+		err = applyManifests("", release.Version, tektonManifests(release))
+		if err != nil {
+			return err
+		}
+
+		err = recordVersion("tekton", release.Version)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, TektonDashboardInfoMsg)
+
+		return nil
+	}
+
+	return tekton
+}
+
+func MakeUninstallTekton() *cobra.Command {
 	var tekton = &cobra.Command{
 		Use:          "tekton",
-		Short:        "Install tekton",
-		Long:         `Install tekton`,
-		Example:      `  coolknative install tekton`,
+		Short:        "Uninstall tekton",
+		Long:         `Uninstall tekton pipelines and dashboard`,
+		Example:      `  coolknative uninstall tekton`,
 		SilenceUsage: true,
 	}
 
+	addUninstallVersionFlag(tekton, "tekton")
+
 	tekton.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
+		keepCRDs, _ := getUninstallFlags(command)
 
-		_, err := kubectlTask("apply", "-f",
-			"https://github.com/tektoncd/pipeline/releases/download/v0.16.3/release.yaml")
+		release, err := getInstalledRelease(command, "tekton")
 		if err != nil {
 			return err
 		}
 
-		_, err = kubectlTask("apply", "-f",
-			"https://github.com/tektoncd/dashboard/releases/download/v0.9.0/tekton-dashboard-release.yaml")
+		err = deleteManifests("", release.Version, tektonManifests(release), keepCRDs)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(TektonDashboardInfoMsg)
+		err = forgetVersion("tekton")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages, "Tekton has been uninstalled.")
 
 		return nil
 	}
@@ -42,6 +87,23 @@ func MakeInstallTekton() *cobra.Command {
 	return tekton
 }
 
+var tektonReadiness = componentHealth{
+	Name: "tekton",
+	Checks: []readinessCheck{
+		deploymentAvailable("tekton-pipelines", "tekton-pipelines-controller"),
+		deploymentAvailable("tekton-pipelines", "tekton-pipelines-webhook"),
+		deploymentAvailable("tekton-pipelines", "tekton-dashboard"),
+	},
+	VersionLabel: "pipeline.tekton.dev/release",
+}
+
+func tektonManifests(release versions.Release) []string {
+	return []string{
+		fmt.Sprintf("https://github.com/tektoncd/pipeline/releases/download/%s/release.yaml", release.Version),
+		fmt.Sprintf("https://github.com/tektoncd/dashboard/releases/download/%s/tekton-dashboard-release.yaml", release.Parts["tekton-dashboard"]),
+	}
+}
+
 const TektonDashboardInfoMsg = `
 #To forward the dashboard to your local machine 
 kubectl proxy
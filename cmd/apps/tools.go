@@ -4,58 +4,16 @@ package apps
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
-	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
 	"text/template"
 )
 
 const defaultVersion = ""
 
-func writeTempFile(input []byte, fileLocation string) (string, error) {
-	var tempDirectory, dirErr = createTempDirectory(".coolknative/")
-	if dirErr != nil {
-		return "", dirErr
-	}
-
-	filename := filepath.Join(tempDirectory, fileLocation)
-
-	err := ioutil.WriteFile(filename, input, 0744)
-	if err != nil {
-		return "", err
-	}
-	return filename, nil
-}
-
-func mergeFlags(existingMap map[string]string, setOverrides []string) error {
-	for _, setOverride := range setOverrides {
-		flag := strings.Split(setOverride, "=")
-		if len(flag) != 2 {
-			return fmt.Errorf("incorrect format for custom flag `%s`", setOverride)
-		}
-		existingMap[flag[0]] = flag[1]
-	}
-	return nil
-}
-
-func createTempDirectory(directory string) (string, error) {
-	tempDirectory := filepath.Join(os.TempDir(), directory)
-	if _, err := os.Stat(tempDirectory); os.IsNotExist(err) {
-		log.Printf(tempDirectory)
-		errr := os.Mkdir(tempDirectory, 0744)
-		if errr != nil {
-			log.Printf("couldnt make dir %s", err)
-			return "", err
-		}
-	}
-
-	return tempDirectory, nil
-}
-
 func buildYAML(inputData interface{}, yamlTemplate string) ([]byte, error) {
 	tmpl, err := template.New("yaml").Parse(yamlTemplate)
 
@@ -82,28 +40,38 @@ func buildCreateYAML(inputData interface{}, yamlTemplate, filelocation string) e
 	return buildActionYAML(inputData, yamlTemplate, filelocation, "create")
 }
 
-func buildActionYAML(inputData interface{}, yamlTemplate string, filelocation, action string) error {
+// buildDeleteYAML deletes the resources of a template rendered with the same
+// input data as the one used to install them.
+func buildDeleteYAML(inputData interface{}, yamlTemplate string) error {
 	yamlBytes, templateErr := buildYAML(inputData, yamlTemplate)
 	if templateErr != nil {
-		log.Print("Unable to install the application. Could not build the templated yaml file for the resources")
+		log.Print("Unable to uninstall the application. Could not build the templated yaml file for the resources")
 		return templateErr
 	}
 
-	tempFile, tempFileErr := writeTempFile(yamlBytes, filelocation)
-	if tempFileErr != nil {
-		log.Print("Unable to save generated yaml file into the temporary directory")
-		return tempFileErr
-	}
+	return deleteYAML("", yamlBytes, false)
+}
 
-	res, err := kubectlTask(action, "-f", tempFile)
+func buildActionYAML(inputData interface{}, yamlTemplate string, filelocation, action string) error {
+	yamlBytes, templateErr := buildYAML(inputData, yamlTemplate)
+	if templateErr != nil {
+		log.Print("Unable to install the application. Could not build the templated yaml file for the resources")
+		return templateErr
+	}
 
+	client, err := getKubeClient()
 	if err != nil {
-		log.Print(err)
 		return err
 	}
 
-	if res.ExitCode != 0 {
-		return fmt.Errorf(res.Stderr)
+	if action == "create" {
+		err = client.Create(context.Background(), yamlBytes, "")
+	} else {
+		err = client.Apply(context.Background(), yamlBytes, "")
+	}
+	if err != nil {
+		log.Printf("Unable to %s %s", action, filelocation)
+		return err
 	}
 	return nil
 }
@@ -111,9 +79,33 @@ func buildActionYAML(inputData interface{}, yamlTemplate string, filelocation, a
 func useDefaultKubeconfig(command *cobra.Command) {
 	kubeConfigPath := getDefaultKubeconfig()
 
+	// The client and helm fall back on $KUBECONFIG, ~/.kube/config or the
+	// in-cluster configuration, so they are only given an explicit path.
+	kubeconfig = ""
+	kubeClient = nil
+	helmClient = nil
 	if command.Flags().Changed("kubeconfig") {
 		kubeConfigPath, _ = command.Flags().GetString("kubeconfig")
+		kubeconfig = kubeConfigPath
 	}
+	kubeContext, _ = command.Flags().GetString("context")
+	bundlePath, _ = command.Flags().GetString("bundle")
+	installBundle = nil
+	lockFile, _ = command.Flags().GetString("lock-file")
+	artifactCache = nil
+	startRendering(command)
+
+	fmt.Fprintf(os.Stderr, "Using kubeconfig: %s\n", kubeConfigPath)
+	if len(kubeContext) > 0 {
+		fmt.Fprintf(os.Stderr, "Using context: %s\n", kubeContext)
+	}
+	if len(bundlePath) > 0 {
+		fmt.Fprintf(os.Stderr, "Using bundle: %s\n", bundlePath)
+	}
+}
 
-	fmt.Printf("Using kubeconfig: %s\n", kubeConfigPath)
+func getUninstallFlags(command *cobra.Command) (keepCRDs bool, keepPVCs bool) {
+	keepCRDs, _ = command.Flags().GetBool("keep-crds")
+	keepPVCs, _ = command.Flags().GetBool("keep-pvcs")
+	return keepCRDs, keepPVCs
 }
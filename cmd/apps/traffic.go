@@ -0,0 +1,442 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// latestTarget designates the latest ready revision of a service, followed as
+// new revisions are deployed.
+const latestTarget = "@latest"
+
+// Revision is a revision of a Knative Service with the traffic it receives.
+type Revision struct {
+	Name string `json:"name"`
+	// Generation is the generation of the configuration which created the
+	// revision, increasing with each deploy.
+	Generation int64     `json:"generation"`
+	Ready      bool      `json:"ready"`
+	Percent    int64     `json:"percent"`
+	Tags       []string  `json:"tags,omitempty"`
+	Latest     bool      `json:"latest"`
+	Created    time.Time `json:"created"`
+}
+
+// trafficPercent routes a share of the requests to a revision.
+type trafficPercent struct {
+	Target  string
+	Percent int64
+}
+
+// trafficTag gives a revision the URL TAG-NAME.DOMAIN.
+type trafficTag struct {
+	Tag    string
+	Target string
+}
+
+// traffic is the spec.traffic of a service, with the shares and the tags kept
+// apart so each command changes one of them.
+type traffic struct {
+	Percents []trafficPercent
+	Tags     []trafficTag
+}
+
+// trafficChange changes the traffic of a service, given its revisions.
+type trafficChange func(t *traffic, revisions []Revision) error
+
+func readTraffic(service *unstructured.Unstructured) traffic {
+	t := traffic{}
+	targets, _, _ := unstructured.NestedSlice(service.Object, "spec", "traffic")
+	for _, target := range targets {
+		entry, ok := target.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(entry, "revisionName")
+		if latest, _, _ := unstructured.NestedBool(entry, "latestRevision"); latest || len(name) == 0 {
+			name = latestTarget
+		}
+		if tag, _, _ := unstructured.NestedString(entry, "tag"); len(tag) > 0 {
+			t.Tags = append(t.Tags, trafficTag{Tag: tag, Target: name})
+		}
+		if percent, _, _ := unstructured.NestedInt64(entry, "percent"); percent > 0 {
+			t.Percents = append(t.Percents, trafficPercent{Target: name, Percent: percent})
+		}
+	}
+	return t
+}
+
+// spec returns spec.traffic, a tagged revision receiving no traffic has its
+// own entry.
+func (t traffic) spec() []interface{} {
+	entries := []interface{}{}
+	for _, p := range t.Percents {
+		entries = append(entries, trafficEntry(p.Target, "", p.Percent))
+	}
+	for _, tag := range t.Tags {
+		entries = append(entries, trafficEntry(tag.Target, tag.Tag, 0))
+	}
+	return entries
+}
+
+func trafficEntry(target, tag string, percent int64) map[string]interface{} {
+	entry := map[string]interface{}{"percent": percent}
+	if target == latestTarget {
+		entry["latestRevision"] = true
+	} else {
+		entry["latestRevision"] = false
+		entry["revisionName"] = target
+	}
+	if len(tag) > 0 {
+		entry["tag"] = tag
+	}
+	return entry
+}
+
+func knativeService(namespace, name string) k8s.Object {
+	return k8s.Object{APIVersion: "serving.knative.dev/v1", Kind: "Service", Namespace: namespace, Name: name}
+}
+
+// ListRevisions returns the revisions of a service, the newest first.
+func ListRevisions(command *cobra.Command, namespace, name string) ([]Revision, error) {
+	useDefaultKubeconfig(command)
+
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := client.Get(context.Background(), knativeService(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	return listRevisions(client, service)
+}
+
+func listRevisions(client k8s.Client, service *unstructured.Unstructured) ([]Revision, error) {
+	items, err := client.List(context.Background(), "serving.knative.dev/v1", "Revision", service.GetNamespace(),
+		metav1.ListOptions{LabelSelector: "serving.knative.dev/service=" + service.GetName()})
+	if err != nil {
+		return nil, err
+	}
+
+	latest, _, _ := unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+	revisions := []Revision{}
+	byName := map[string]int{}
+	for _, item := range items {
+		generation, _ := strconv.ParseInt(item.GetLabels()["serving.knative.dev/configurationGeneration"], 10, 64)
+		ready, _ := k8s.ConditionTrue("Ready")(&item)
+		byName[item.GetName()] = len(revisions)
+		revisions = append(revisions, Revision{
+			Name:       item.GetName(),
+			Generation: generation,
+			Ready:      ready,
+			Latest:     item.GetName() == latest,
+			Created:    item.GetCreationTimestamp().Time,
+		})
+	}
+
+	// The status gives the traffic actually routed, @latest resolved
+	targets, _, _ := unstructured.NestedSlice(service.Object, "status", "traffic")
+	for _, target := range targets {
+		entry, ok := target.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(entry, "revisionName")
+		i, ok := byName[name]
+		if !ok {
+			continue
+		}
+		percent, _, _ := unstructured.NestedInt64(entry, "percent")
+		revisions[i].Percent += percent
+		if tag, _, _ := unstructured.NestedString(entry, "tag"); len(tag) > 0 {
+			revisions[i].Tags = append(revisions[i].Tags, tag)
+		}
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Generation > revisions[j].Generation
+	})
+	return revisions, nil
+}
+
+// updateTrafficAttempts bounds the retries of updateTraffic when the service
+// is changed at the same time, by a deploy or another traffic command.
+const updateTrafficAttempts = 5
+
+// updateTraffic changes the traffic of a service, then waits for its route to
+// converge and prints the URLs of its tags.
+func updateTraffic(command *cobra.Command, namespace, name string, timeout time.Duration, change trafficChange) error {
+	useDefaultKubeconfig(command)
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	obj := knativeService(namespace, name)
+	for attempt := 1; ; attempt++ {
+		err = patchTraffic(client, obj, change)
+		// The change is made again on the service as it is now
+		if apierrors.IsConflict(err) && attempt < updateTrafficAttempts {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	fmt.Fprintf(messages, "Waiting for the route of %s/%s\n", namespace, name)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = client.Wait(ctx, obj, latestReady)
+	if err != nil {
+		if res, getErr := client.Get(context.Background(), obj); getErr == nil {
+			if message := readyMessage(res); len(message) > 0 {
+				return fmt.Errorf("service %s/%s is not ready: %s", namespace, name, message)
+			}
+		}
+		return err
+	}
+
+	service, err := client.Get(context.Background(), obj)
+	if err != nil {
+		return err
+	}
+	targets, _, _ := unstructured.NestedSlice(service.Object, "status", "traffic")
+	for _, target := range targets {
+		entry, _ := target.(map[string]interface{})
+		tag, _, _ := unstructured.NestedString(entry, "tag")
+		url, _, _ := unstructured.NestedString(entry, "url")
+		if len(tag) > 0 && len(url) > 0 {
+			fmt.Fprintf(messages, "%s: %s\n", tag, url)
+		}
+	}
+	return nil
+}
+
+// patchTraffic makes the change on the traffic of the service as it is read,
+// failing with a conflict when the service changed meanwhile.
+func patchTraffic(client k8s.Client, obj k8s.Object, change trafficChange) error {
+	service, err := client.Get(context.Background(), obj)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := listRevisions(client, service)
+	if err != nil {
+		return err
+	}
+
+	t := readTraffic(service)
+	err = change(&t, revisions)
+	if err != nil {
+		return err
+	}
+
+	// A merge patch replaces the whole list of targets, the resource version
+	// makes it fail rather than drop a change made since the service was read
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": service.GetResourceVersion()},
+		"spec":     map[string]interface{}{"traffic": t.spec()},
+	})
+	if err != nil {
+		return err
+	}
+	return client.Patch(context.Background(), obj, types.MergePatchType, patch)
+}
+
+// checkTarget verifies that a revision belongs to the service, @latest is
+// always valid.
+func checkTarget(target string, revisions []Revision) error {
+	if target == latestTarget {
+		return nil
+	}
+	names := []string{}
+	for _, r := range revisions {
+		if r.Name == target {
+			return nil
+		}
+		names = append(names, r.Name)
+	}
+	return fmt.Errorf("no revision %s, one of %v or %s", target, names, latestTarget)
+}
+
+// SplitTraffic routes the requests by percentage, given as REVISION=PERCENT
+// summing to 100. The tags are kept.
+func SplitTraffic(command *cobra.Command, namespace, name string, assignments []string, timeout time.Duration) error {
+	return updateTraffic(command, namespace, name, timeout, splitTraffic(assignments))
+}
+
+func splitTraffic(assignments []string) trafficChange {
+	return func(t *traffic, revisions []Revision) error {
+		percents := []trafficPercent{}
+		total := int64(0)
+		for _, assignment := range assignments {
+			i := strings.LastIndex(assignment, "=")
+			if i <= 0 {
+				return fmt.Errorf("split takes REVISION=PERCENT, got %q", assignment)
+			}
+			target := assignment[:i]
+			percent, err := strconv.ParseInt(assignment[i+1:], 10, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return fmt.Errorf("the percent of %s must be between 0 and 100, got %q", target, assignment[i+1:])
+			}
+			if err := checkTarget(target, revisions); err != nil {
+				return err
+			}
+			if percent > 0 {
+				percents = append(percents, trafficPercent{Target: target, Percent: percent})
+			}
+			total += percent
+		}
+		if total != 100 {
+			return fmt.Errorf("the percents must sum to 100, got %d", total)
+		}
+		t.Percents = percents
+		return nil
+	}
+}
+
+// TagRevisions gives revisions the URL TAG-NAME.DOMAIN, given as
+// TAG=REVISION. An existing tag is moved.
+func TagRevisions(command *cobra.Command, namespace, name string, assignments []string, timeout time.Duration) error {
+	return updateTraffic(command, namespace, name, timeout, func(t *traffic, revisions []Revision) error {
+		for _, assignment := range assignments {
+			i := strings.Index(assignment, "=")
+			if i <= 0 {
+				return fmt.Errorf("tag takes TAG=REVISION, got %q", assignment)
+			}
+			tag, target := assignment[:i], assignment[i+1:]
+			if err := checkTarget(target, revisions); err != nil {
+				return err
+			}
+			t.Tags = append(removeTag(t.Tags, tag), trafficTag{Tag: tag, Target: target})
+		}
+		return nil
+	})
+}
+
+// UntagRevisions removes tags, the revisions keep their traffic.
+func UntagRevisions(command *cobra.Command, namespace, name string, tags []string, timeout time.Duration) error {
+	return updateTraffic(command, namespace, name, timeout, func(t *traffic, revisions []Revision) error {
+		for _, tag := range tags {
+			if _, ok := findTag(t.Tags, tag); !ok {
+				return fmt.Errorf("no tag %s on %s/%s", tag, namespace, name)
+			}
+			t.Tags = removeTag(t.Tags, tag)
+		}
+		return nil
+	})
+}
+
+// PromoteTag routes all the requests to the revision of a tag. A tag of
+// @latest is pinned to the revision it currently reaches, so that a later
+// deploy does not receive the traffic.
+func PromoteTag(command *cobra.Command, namespace, name, tag string, timeout time.Duration) error {
+	return updateTraffic(command, namespace, name, timeout, promoteTag(namespace, name, tag))
+}
+
+func promoteTag(namespace, name, tag string) trafficChange {
+	return func(t *traffic, revisions []Revision) error {
+		target, ok := findTag(t.Tags, tag)
+		if !ok {
+			return fmt.Errorf("no tag %s on %s/%s", tag, namespace, name)
+		}
+		if target == latestTarget {
+			target = taggedRevision(revisions, tag)
+			if len(target) == 0 {
+				return fmt.Errorf("tag %s of %s/%s designates %s, which is not routed yet", tag, namespace, name, latestTarget)
+			}
+		}
+		t.Percents = []trafficPercent{{Target: target, Percent: 100}}
+		return nil
+	}
+}
+
+// taggedRevision returns the revision a tag is routed to, as given by the
+// status of the service.
+func taggedRevision(revisions []Revision, tag string) string {
+	for _, r := range revisions {
+		for _, t := range r.Tags {
+			if t == tag {
+				return r.Name
+			}
+		}
+	}
+	return ""
+}
+
+// RollbackService routes all the requests to the newest ready revision older
+// than the newest one receiving traffic, and returns its name. The traffic
+// then no longer follows the deploys, until it is given back to @latest.
+func RollbackService(command *cobra.Command, namespace, name string, timeout time.Duration) (string, error) {
+	var previous string
+	rollback := rollbackTraffic(namespace, name)
+	err := updateTraffic(command, namespace, name, timeout, func(t *traffic, revisions []Revision) error {
+		if err := rollback(t, revisions); err != nil {
+			return err
+		}
+		previous = t.Percents[0].Target
+		return nil
+	})
+	return previous, err
+}
+
+func rollbackTraffic(namespace, name string) trafficChange {
+	return func(t *traffic, revisions []Revision) error {
+		current := -1
+		for i, r := range revisions {
+			if r.Percent > 0 {
+				current = i
+				break
+			}
+		}
+		if current < 0 {
+			return fmt.Errorf("no revision of %s/%s receives traffic", namespace, name)
+		}
+
+		for _, r := range revisions[current+1:] {
+			if r.Ready {
+				t.Percents = []trafficPercent{{Target: r.Name, Percent: 100}}
+				return nil
+			}
+		}
+		return fmt.Errorf("no ready revision of %s/%s older than %s", namespace, name, revisions[current].Name)
+	}
+}
+
+func findTag(tags []trafficTag, tag string) (string, bool) {
+	for _, t := range tags {
+		if t.Tag == tag {
+			return t.Target, true
+		}
+	}
+	return "", false
+}
+
+func removeTag(tags []trafficTag, tag string) []trafficTag {
+	kept := []trafficTag{}
+	for _, t := range tags {
+		if t.Tag != tag {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
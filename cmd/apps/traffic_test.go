@@ -0,0 +1,187 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRevisions are the revisions of a service, the newest first: web-3 is
+// failing, web-2 receives the traffic and has the tag candidate.
+var testRevisions = []Revision{
+	{Name: "web-3", Generation: 3},
+	{Name: "web-2", Generation: 2, Ready: true, Percent: 100, Tags: []string{"candidate"}, Latest: true},
+	{Name: "web-1", Generation: 1, Ready: true},
+}
+
+func TestSplitTraffic(t *testing.T) {
+	tags := []trafficTag{{Tag: "candidate", Target: latestTarget}}
+	for name, test := range map[string]struct {
+		assignments []string
+		want        []trafficPercent
+		wantErr     string
+	}{
+		"split": {
+			assignments: []string{"web-1=80", "@latest=20"},
+			want:        []trafficPercent{{Target: "web-1", Percent: 80}, {Target: latestTarget, Percent: 20}},
+		},
+		"zero percent is dropped": {
+			assignments: []string{"web-1=0", "web-2=100"},
+			want:        []trafficPercent{{Target: "web-2", Percent: 100}},
+		},
+		"missing percent": {
+			assignments: []string{"web-1"},
+			wantErr:     `split takes REVISION=PERCENT, got "web-1"`,
+		},
+		"negative": {
+			assignments: []string{"web-1=-10", "web-2=110"},
+			wantErr:     `the percent of web-1 must be between 0 and 100, got "-10"`,
+		},
+		"above 100": {
+			assignments: []string{"web-1=110"},
+			wantErr:     `the percent of web-1 must be between 0 and 100, got "110"`,
+		},
+		"not a number": {
+			assignments: []string{"web-1=half"},
+			wantErr:     `the percent of web-1 must be between 0 and 100, got "half"`,
+		},
+		"unknown revision": {
+			assignments: []string{"web-9=100"},
+			wantErr:     "no revision web-9",
+		},
+		"sum": {
+			assignments: []string{"web-1=50", "web-2=40"},
+			wantErr:     "the percents must sum to 100, got 90",
+		},
+	} {
+		tr := traffic{Tags: tags}
+		err := splitTraffic(test.assignments)(&tr, testRevisions)
+		if len(test.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: want an error containing %q, got %v", name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tr.Percents, test.want) {
+			t.Errorf("%s: want %v, got %v", name, test.want, tr.Percents)
+		}
+		if !reflect.DeepEqual(tr.Tags, tags) {
+			t.Errorf("%s: want the tags kept, got %v", name, tr.Tags)
+		}
+	}
+}
+
+func TestPromoteTag(t *testing.T) {
+	for name, test := range map[string]struct {
+		tag       string
+		tags      []trafficTag
+		revisions []Revision
+		want      string
+		wantErr   string
+	}{
+		"revision": {
+			tag:       "candidate",
+			tags:      []trafficTag{{Tag: "candidate", Target: "web-1"}},
+			revisions: testRevisions,
+			want:      "web-1",
+		},
+		"latest is pinned": {
+			tag:       "candidate",
+			tags:      []trafficTag{{Tag: "candidate", Target: latestTarget}},
+			revisions: testRevisions,
+			want:      "web-2",
+		},
+		"latest not routed yet": {
+			tag:       "candidate",
+			tags:      []trafficTag{{Tag: "candidate", Target: latestTarget}},
+			revisions: []Revision{{Name: "web-1", Ready: true}},
+			wantErr:   "tag candidate of default/web designates @latest, which is not routed yet",
+		},
+		"unknown tag": {
+			tag:       "other",
+			tags:      []trafficTag{{Tag: "candidate", Target: "web-1"}},
+			revisions: testRevisions,
+			wantErr:   "no tag other on default/web",
+		},
+	} {
+		tr := traffic{Percents: []trafficPercent{{Target: latestTarget, Percent: 100}}, Tags: test.tags}
+		err := promoteTag("default", "web", test.tag)(&tr, test.revisions)
+		if len(test.wantErr) > 0 {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: want error %q, got %v", name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		want := []trafficPercent{{Target: test.want, Percent: 100}}
+		if !reflect.DeepEqual(tr.Percents, want) {
+			t.Errorf("%s: want %v, got %v", name, want, tr.Percents)
+		}
+	}
+}
+
+func TestRollbackTraffic(t *testing.T) {
+	for name, test := range map[string]struct {
+		revisions []Revision
+		want      string
+		wantErr   string
+	}{
+		"previous": {
+			revisions: testRevisions,
+			want:      "web-1",
+		},
+		"failing revisions are skipped": {
+			revisions: []Revision{
+				{Name: "web-4", Generation: 4, Ready: true, Percent: 100},
+				{Name: "web-3", Generation: 3},
+				{Name: "web-2", Generation: 2, Ready: true},
+			},
+			want: "web-2",
+		},
+		"newest receiving traffic": {
+			revisions: []Revision{
+				{Name: "web-3", Generation: 3, Ready: true, Percent: 10},
+				{Name: "web-2", Generation: 2, Ready: true, Percent: 90},
+				{Name: "web-1", Generation: 1, Ready: true},
+			},
+			want: "web-2",
+		},
+		"no traffic": {
+			revisions: []Revision{{Name: "web-1", Generation: 1, Ready: true}},
+			wantErr:   "no revision of default/web receives traffic",
+		},
+		"no older revision": {
+			revisions: []Revision{
+				{Name: "web-2", Generation: 2, Ready: true, Percent: 100},
+				{Name: "web-1", Generation: 1},
+			},
+			wantErr: "no ready revision of default/web older than web-2",
+		},
+	} {
+		tr := traffic{Percents: []trafficPercent{{Target: latestTarget, Percent: 100}}}
+		err := rollbackTraffic("default", "web")(&tr, test.revisions)
+		if len(test.wantErr) > 0 {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: want error %q, got %v", name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		want := []trafficPercent{{Target: test.want, Percent: 100}}
+		if !reflect.DeepEqual(tr.Percents, want) {
+			t.Errorf("%s: want %v, got %v", name, want, tr.Percents)
+		}
+	}
+}
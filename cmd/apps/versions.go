@@ -0,0 +1,170 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/eskersoftware/coolknative/pkg/k8s"
+	"github.com/eskersoftware/coolknative/pkg/versions"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// versionsConfigMap records the version of each installed component, so the
+// versions of the components installed later can be checked against it.
+var versionsConfigMap = k8s.Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "coolknative-versions"}
+
+// addVersionFlags adds the flags selecting the version of a component of the
+// compatibility matrix.
+func addVersionFlags(command *cobra.Command, name string) {
+	c, err := versions.Get(name)
+	if err != nil {
+		panic(err)
+	}
+
+	command.Flags().String("version", c.Default().Version,
+		fmt.Sprintf("Version of %s, one of %s", name, strings.Join(c.Supported(), ", ")))
+	command.Flags().Bool("skip-version-check", false,
+		"Install a version missing from the compatibility matrix or mismatching the installed components")
+}
+
+// addUninstallVersionFlag adds the flag selecting the version of a component
+// to uninstall.
+func addUninstallVersionFlag(command *cobra.Command, name string) {
+	command.Flags().String("version", "", fmt.Sprintf("Version of %s to uninstall, the installed one by default", name))
+}
+
+// getRelease returns the release selected with --version, checked against
+// the compatibility matrix and the versions of the installed components.
+func getRelease(command *cobra.Command, name string) (versions.Release, error) {
+	c, err := versions.Get(name)
+	if err != nil {
+		return versions.Release{}, err
+	}
+
+	version, _ := command.Flags().GetString("version")
+	force, _ := command.Flags().GetBool("skip-version-check")
+
+	release, err := c.Release(version, force)
+	if err != nil {
+		return versions.Release{}, fmt.Errorf("%s, use --skip-version-check to install it anyway", err)
+	}
+	if _, err := c.Release(release.Version, false); err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+
+	installed, err := getInstalledVersions()
+	if err != nil {
+		return versions.Release{}, err
+	}
+
+	err = c.Check(release.Version, installed)
+	if err != nil && !force {
+		return versions.Release{}, fmt.Errorf("%s, use --skip-version-check to install it anyway", err)
+	}
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+
+	return release, nil
+}
+
+// getInstalledRelease returns the release to uninstall: the one given with
+// --version, else the installed one, else the default one.
+func getInstalledRelease(command *cobra.Command, name string) (versions.Release, error) {
+	c, err := versions.Get(name)
+	if err != nil {
+		return versions.Release{}, err
+	}
+
+	version, _ := command.Flags().GetString("version")
+	if len(version) == 0 {
+		installed, err := getInstalledVersions()
+		if err != nil {
+			return versions.Release{}, err
+		}
+		version = installed[name]
+	}
+
+	return c.Release(version, true)
+}
+
+// getInstalledVersions returns the versions of the installed components, by
+// component name.
+func getInstalledVersions() (map[string]string, error) {
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Get(context.Background(), versionsConfigMap)
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	installed := map[string]string{}
+	data, _ := res.Object["data"].(map[string]interface{})
+	for name, version := range data {
+		installed[name], _ = version.(string)
+	}
+	return installed, nil
+}
+
+// recordVersion stores the version of an installed component, empty when it
+// is not versioned.
+func recordVersion(name, version string) error {
+	if isDryRun() {
+		return nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{name: version},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = client.Patch(context.Background(), versionsConfigMap, types.MergePatchType, patch)
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	manifest := fmt.Sprintf("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\n  namespace: %s\ndata:\n  %s: %q\n",
+		versionsConfigMap.Name, versionsConfigMap.Namespace, name, version)
+	return client.Create(context.Background(), []byte(manifest), versionsConfigMap.Namespace)
+}
+
+// forgetVersion removes the version of an uninstalled component.
+func forgetVersion(name string) error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{name: nil},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = client.Patch(context.Background(), versionsConfigMap, types.MergePatchType, patch)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -3,160 +3,183 @@
 package apps
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/eskersoftware/coolknative/pkg"
+	"github.com/eskersoftware/coolknative/pkg/k8s"
 	"github.com/spf13/cobra"
-	"os/exec"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// waitInterval is the delay between two checks of a component.
+const waitInterval = 2 * time.Second
+
+// progressInterval is the delay between two reports of the components still
+// being waited for.
+const progressInterval = 15 * time.Second
+
 func MakeWaitInstall() *cobra.Command {
 	var waitInstall = &cobra.Command{
-		Use:          "wait-install",
-		Short:        "Install wait-install",
-		Long:         `Install wait-install.`,
-		Example:      `  coolknative install wait-install`,
+		Use:   "wait-install",
+		Short: "Wait for the installed components to be ready",
+		Long: `Wait for the components installed by coolknative to be ready. The readiness
+checks of all the components run concurrently, until they are all ready or
+the timeout is reached. The known components installed by other tools, like
+loki, are waited for as well when their resources exist.`,
+		Example: `  coolknative install wait-install
+  coolknative install wait-install --timeout 20m`,
 		SilenceUsage: true,
 	}
 
+	waitInstall.Flags().Duration("timeout", 15*time.Minute, "Time to wait for all the components to be ready")
+
 	waitInstall.RunE = func(command *cobra.Command, args []string) error {
 		useDefaultKubeconfig(command)
+		timeout, _ := command.Flags().GetDuration("timeout")
 
-		err := kubectlWait("available", "knative-serving", "deployment", "3scale-kourier-control")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "kourier-system", "deployment", "3scale-kourier-gateway")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-serving", "deployment", "activator")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-serving", "deployment", "autoscaler")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-serving", "deployment", "autoscaler-hpa")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-serving", "deployment", "webhook")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-eventing", "deployment", "mt-broker-controller")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-eventing", "deployment", "eventing-controller")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-eventing", "deployment", "eventing-webhook")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("available", "knative-eventing", "deployment", "natss-ch-controller")
+		client, err := getKubeClient()
 		if err != nil {
 			return err
 		}
-		err = kubectlWait("available", "knative-eventing", "deployment", "natss-ch-dispatcher")
+
+		installed, err := getInstalledVersions()
 		if err != nil {
 			return err
 		}
 
-		err = kubectlWait("available", "default", "deployment", "nats-operator")
+		components, err := waitedComponents(client, installed)
 		if err != nil {
 			return err
 		}
-		err = kubectlWait("available", "default", "deployment", "nats-streaming-operator")
-		if err != nil {
-			return err
+		if len(components) == 0 {
+			fmt.Fprintln(messages, "No installed component to wait for.")
+			return nil
 		}
 
-		err = kubectlWait("available", "loki", "deployment", "loki-stack-grafana")
-		if err != nil {
-			return err
-		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
 
-		// https://github.com/kubernetes/kubernetes/issues/79606
-		// We cannot wait yet for statefulset so we wait for the pods
-		err = kubectlWait("ready", "default", "pod", "nats-1")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "default", "pod", "nats-2")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "default", "pod", "nats-3")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "default", "pod", "nats-streaming-1")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "default", "pod", "nats-streaming-2")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "default", "pod", "nats-streaming-3")
+		err = waitComponents(ctx, client, components, timeout)
 		if err != nil {
 			return err
 		}
 
-		err = kubectlWait("ready", "redis", "pod", "redis-master-0")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "redis", "pod", "redis-slave-0")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "redis", "pod", "redis-slave-1")
-		if err != nil {
-			return err
-		}
+		fmt.Fprintln(messages, WaitInstallInstallMsg)
 
-		err = kubectlWait("ready", "minio", "pod", "minio-zone-0-0")
-		if err != nil {
-			return err
-		}
-		err = kubectlWait("ready", "minio", "pod", "minio-zone-0-1")
-		if err != nil {
-			return err
+		return nil
+	}
+
+	return waitInstall
+}
+
+// waitedComponents returns the components recorded as installed, along with
+// the known components whose resources exist without being recorded, as they
+// were installed by another tool.
+func waitedComponents(client k8s.Client, installed map[string]string) ([]componentHealth, error) {
+	components := []componentHealth{}
+	for _, component := range knownComponents(installed) {
+		if _, ok := installed[component.Name]; ok {
+			components = append(components, component)
+			continue
 		}
-		err = kubectlWait("ready", "minio", "pod", "minio-zone-0-2")
+
+		status, err := checkComponent(client, component)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = kubectlWait("ready", "minio", "pod", "minio-zone-0-3")
-		if err != nil {
-			return err
+		if status.Status != StatusMissing {
+			components = append(components, component)
 		}
-		err = kubectlWait("ready", "loki", "pod", "loki-stack-0")
-		if err != nil {
-			return err
+	}
+	return components, nil
+}
+
+// waitComponents checks the components concurrently until they are all ready,
+// reporting the ones still pending at regular intervals.
+func waitComponents(ctx context.Context, client k8s.Client, components []componentHealth, timeout time.Duration) error {
+	start := time.Now()
+	updates := make(chan ComponentStatus)
+	done := make(chan string)
+
+	for _, component := range components {
+		go func(component componentHealth) {
+			wait.PollUntilContextCancel(ctx, waitInterval, true, func(ctx context.Context) (bool, error) {
+				status, err := checkComponent(client, component)
+				if err != nil {
+					// The API server may be briefly unavailable while CRDs and webhooks settle
+					log.Printf("unable to check %s: %s\n", component.Name, err)
+					return false, nil
+				}
+				select {
+				case updates <- status:
+				case <-ctx.Done():
+				}
+				return status.Status == StatusReady, nil
+			})
+			done <- component.Name
+		}(component)
+	}
+
+	pending := map[string]ComponentStatus{}
+	for _, component := range components {
+		pending[component.Name] = ComponentStatus{Name: component.Name, Status: StatusMissing}
+	}
+
+	fmt.Fprintf(messages, "Waiting for %d components: %s\n", len(components), strings.Join(pendingNames(pending), ", "))
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for running := len(components); running > 0; {
+		select {
+		case status := <-updates:
+			if _, ok := pending[status.Name]; !ok {
+				continue
+			}
+			if status.Status == StatusReady {
+				delete(pending, status.Name)
+				fmt.Fprintf(messages, "%s is ready (%s)\n", status.Name, time.Since(start).Round(time.Second))
+				continue
+			}
+			pending[status.Name] = status
+		case <-done:
+			running--
+		case <-ticker.C:
+			fmt.Fprintf(messages, "Still waiting after %s for: %s\n", time.Since(start).Round(time.Second), describePending(pending))
 		}
-		fmt.Println(WaitInstallInstallMsg)
+	}
 
-		return nil
+	if len(pending) > 0 {
+		return fmt.Errorf("components not ready after %s: %s", timeout, describePending(pending))
 	}
+	return nil
+}
 
-	return waitInstall
+func pendingNames(pending map[string]ComponentStatus) []string {
+	names := []string{}
+	for name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func kubectlWait(condition string, namespace string, resourceType string, resourceName string) error {
-	timeout := "600s"
-	cmd := exec.Command("kubectl", "wait", "--for=condition="+condition, "-n", namespace, resourceType, resourceName, "--timeout=" + timeout)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println("Could not wait for " + resourceType + " " + resourceName + " in " + namespace + " to be " + condition + " after " + timeout + fmt.Sprint(err) + ": " + string(output))
-		return err
+func describePending(pending map[string]ComponentStatus) string {
+	descriptions := []string{}
+	for _, name := range pendingNames(pending) {
+		status := pending[name]
+		if len(status.Message) > 0 {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", name, status.Message))
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", name, status.Status))
+		}
 	}
-	return nil
+	return strings.Join(descriptions, ", ")
 }
 
 const WaitInstallInfoMsg = `
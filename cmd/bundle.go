@@ -0,0 +1,119 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/eskersoftware/coolknative/pkg/bundle"
+	"github.com/spf13/cobra"
+)
+
+func MakeBundle() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "bundle",
+		Short: "Package the artifacts of the installers for clusters without internet access",
+		Long: `Package the release manifests, the minio operator kustomization and the
+redis chart fetched by the installers into a single tarball.
+Give this tarball to "install", "uninstall", "template" or "apply" with
+--bundle to use it instead of the network.`,
+		Example: `  coolknative bundle create
+  coolknative bundle create --output bundle.tgz
+  coolknative bundle create --version knative-serving=v0.17.0 --version redis=12.0.0
+  coolknative bundle list coolknative-bundle-0.1.0.tgz`,
+		SilenceUsage: false,
+	}
+
+	command.AddCommand(makeBundleCreate())
+	command.AddCommand(makeBundleList())
+
+	return command
+}
+
+func makeBundleCreate() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "create",
+		Short:        "Download the artifacts of the installers into a bundle",
+		Example:      `  coolknative bundle create --output coolknative-bundle.tgz`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().StringP("output", "o", "", "Bundle file to write, coolknative-bundle-VERSION.tgz by default")
+	command.Flags().StringArray("version", []string{}, "Version of a component as NAME=VERSION, the default version of the installer otherwise")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		version := Version
+		if len(version) == 0 {
+			version = "dev"
+		}
+
+		output, _ := command.Flags().GetString("output")
+		if len(output) == 0 {
+			output = fmt.Sprintf("coolknative-bundle-%s.tgz", version)
+		}
+
+		componentVersions, _ := command.Flags().GetStringArray("version")
+		selected := map[string]string{}
+		for _, v := range componentVersions {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("--version requires NAME=VERSION, got %q", v)
+			}
+			selected[parts[0]] = parts[1]
+		}
+
+		b, err := apps.CreateBundle(version, selected)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = b.Write(file)
+		if err != nil {
+			return fmt.Errorf("unable to write bundle %s: %s", output, err)
+		}
+
+		fmt.Printf("Bundle %s has been created with %d artifacts.\n", output, len(b.Artifacts))
+		return nil
+	}
+
+	return command
+}
+
+func makeBundleList() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "list BUNDLE",
+		Short:        "List the artifacts of a bundle",
+		Example:      `  coolknative bundle list coolknative-bundle-0.1.0.tgz`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		b, err := bundle.Open(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Version: %s\nCreated: %s\n\n", b.Version, b.Created.Format(time.RFC3339))
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ARTIFACT\tSIZE\tSHA256")
+		for _, a := range b.Artifacts {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", a.Key, b.Size(a), a.SHA256)
+		}
+		return w.Flush()
+	}
+
+	return command
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+)
+
+func MakeConfig() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "config",
+		Short: "Read and change the configuration of knative",
+		Long: `Read and change single keys of the ConfigMaps of knative-serving and
+knative-eventing. Keys are written COMPONENT.NAME.KEY for the KEY of the
+config-NAME ConfigMap of the knative-COMPONENT namespace.`,
+		Example: `  coolknative config get serving.autoscaler
+  coolknative config set serving.autoscaler.container-concurrency-target-default=50`,
+		Run: func(command *cobra.Command, args []string) {
+			command.Help()
+		},
+	}
+
+	command.PersistentFlags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.PersistentFlags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+
+	command.AddCommand(makeConfigGet())
+	command.AddCommand(makeConfigSet())
+
+	return command
+}
+
+func makeConfigGet() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a key, or all the keys of a ConfigMap",
+		Example: `  coolknative config get serving.network.ingress.class
+  coolknative config get serving.autoscaler`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		values, err := apps.GetKnativeConfig(command, args[0])
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", key, values[key])
+		}
+		return w.Flush()
+	}
+
+	return command
+}
+
+func makeConfigSet() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "set KEY=VALUE...",
+		Short: "Merge-patch keys of the ConfigMaps",
+		Long: `Set keys of the ConfigMaps of knative, leaving their other keys as they are.
+The values of the known keys are validated, and the changes are printed as a
+diff before being written.`,
+		Example: `  coolknative config set serving.autoscaler.container-concurrency-target-default=50
+  coolknative config set serving.features.kubernetes.podspec-fieldref=enabled --diff`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().Bool("diff", false, "Only print the changes, without writing them")
+	command.Flags().Bool("force", false, "Set keys unknown to coolknative, for newer knative versions")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		force, _ := command.Flags().GetBool("force")
+		diffOnly, _ := command.Flags().GetBool("diff")
+
+		return apps.SetKnativeConfig(command, args, force, diffOnly)
+	}
+
+	return command
+}
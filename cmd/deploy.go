@@ -0,0 +1,125 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+)
+
+func MakeDeploy() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "deploy [NAME]",
+		Short: "Create or update a Knative Service",
+		Long: `Create or update a Knative Service running a container image, from the flags
+or from a YAML spec given with --file, the flags overriding it. With
+--cicd-env, the service reads the minio and token credentials and the
+domain-config settings created by "install cicd". Waits for the service to be
+ready and prints its URL.`,
+		Example: `  coolknative deploy hello --image gcr.io/knative-samples/helloworld-go --env TARGET=world
+  coolknative deploy api -n namespace1-api --image me/api:1.2 --cicd-env --min-scale 1 --max-scale 5
+  coolknative deploy grpc --image me/grpc --port 9000 --h2c --cluster-local
+  coolknative deploy -f hello.yaml --image me/hello:1.3`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.Flags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+	command.Flags().StringP("file", "f", "", "YAML spec of the service, with the fields name, namespace, image, env, secretEnv, configMapEnv, cicdEnv, minScale, maxScale, concurrency, clusterLocal, port, h2c and pullSecret")
+	command.Flags().StringP("namespace", "n", "default", "Namespace of the service")
+	command.Flags().String("image", "", "Container image of the service")
+	command.Flags().StringArray("env", []string{}, "Environment variable, NAME=VALUE")
+	command.Flags().StringArray("env-from-secret", []string{}, "Environment variable read from a Secret, NAME=SECRET:KEY")
+	command.Flags().StringArray("env-from-configmap", []string{}, "Environment variable read from a ConfigMap, NAME=CONFIGMAP:KEY")
+	command.Flags().Bool("cicd-env", false, "Read the minio and token Secrets and the domain-config ConfigMap created by \"install cicd\"")
+	command.Flags().Int("min-scale", 0, "Minimum number of pods, 0 to scale to zero")
+	command.Flags().Int("max-scale", 0, "Maximum number of pods, 0 for no limit")
+	command.Flags().Int("concurrency", 0, "Maximum number of concurrent requests per pod, 0 for no limit")
+	command.Flags().Bool("cluster-local", false, "Only expose the service inside the cluster")
+	command.Flags().Int("port", 0, "Port the container listens on, 8080 by default")
+	command.Flags().Bool("h2c", false, "Serve HTTP/2 without TLS, for gRPC")
+	command.Flags().String("pull-secret", "", "Secret used to pull the image, regcred by default when it exists in the namespace")
+	command.Flags().Bool("wait", true, "Wait for the service to be ready and print its URL")
+	command.Flags().Duration("timeout", apps.DefaultDeployTimeout, "How long to wait for the service to be ready")
+	command.Flags().Bool("dry-run", false, "Render the service without touching the cluster")
+
+	command.PersistentPostRunE = func(command *cobra.Command, args []string) error {
+		return apps.WriteRendered(command)
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		spec := apps.DeploySpec{}
+		if file, _ := command.Flags().GetString("file"); len(file) > 0 {
+			var err error
+			spec, err = apps.ReadDeploySpec(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(args) == 1 {
+			spec.Name = args[0]
+		}
+		flags := command.Flags()
+		if flags.Changed("namespace") || len(spec.Namespace) == 0 {
+			spec.Namespace, _ = flags.GetString("namespace")
+		}
+		if flags.Changed("image") {
+			spec.Image, _ = flags.GetString("image")
+		}
+
+		var err error
+		for _, env := range []struct {
+			flag   string
+			values *map[string]string
+		}{{"env", &spec.Env}, {"env-from-secret", &spec.SecretEnv}, {"env-from-configmap", &spec.ConfigMapEnv}} {
+			assignments, _ := flags.GetStringArray(env.flag)
+			*env.values, err = apps.ParseEnv(*env.values, assignments, env.flag)
+			if err != nil {
+				return err
+			}
+		}
+
+		if flags.Changed("cicd-env") {
+			spec.CicdEnv, _ = flags.GetBool("cicd-env")
+		}
+		for _, scale := range []struct {
+			flag  string
+			value **int
+		}{{"min-scale", &spec.MinScale}, {"max-scale", &spec.MaxScale}, {"concurrency", &spec.Concurrency}} {
+			if flags.Changed(scale.flag) {
+				value, _ := flags.GetInt(scale.flag)
+				*scale.value = &value
+			}
+		}
+		if flags.Changed("cluster-local") {
+			spec.ClusterLocal, _ = flags.GetBool("cluster-local")
+		}
+		if flags.Changed("port") {
+			spec.Port, _ = flags.GetInt("port")
+		}
+		if flags.Changed("h2c") {
+			spec.H2C, _ = flags.GetBool("h2c")
+		}
+		if flags.Changed("pull-secret") {
+			spec.PullSecret, _ = flags.GetString("pull-secret")
+		}
+
+		wait, _ := flags.GetBool("wait")
+		timeout, _ := flags.GetDuration("timeout")
+
+		url, err := apps.Deploy(command, spec, wait, timeout)
+		if err != nil {
+			return err
+		}
+		if len(url) > 0 {
+			fmt.Println(url)
+		}
+		return nil
+	}
+
+	return command
+}
@@ -28,7 +28,16 @@ command.`,
 	}
 
 	command.PersistentFlags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.PersistentFlags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
 	command.PersistentFlags().Bool("wait", false, "If we should wait for the resource to be ready before returning (helm3 only, default false)")
+	command.PersistentFlags().Bool("dry-run", false, "Render the manifests which would be applied without touching the cluster")
+	command.PersistentFlags().String("output-dir", "", "With --dry-run, write the manifests to NAME.yaml in this directory instead of stdout")
+	command.PersistentFlags().String("bundle", "", "Read the manifests, charts and helm from a bundle made with \"coolknative bundle create\" instead of the network")
+	command.PersistentFlags().String("lock-file", "coolknative.lock", "Pin the downloaded manifests and charts in this file, and fail when they change upstream, empty to disable")
+
+	command.PersistentPostRunE = func(command *cobra.Command, args []string) error {
+		return apps.WriteRendered(command)
+	}
 
 	command.RunE = func(command *cobra.Command, args []string) error {
 
@@ -51,6 +60,8 @@ command.`,
 	command.AddCommand(apps.MakeInstallKnativeServing())
 	command.AddCommand(apps.MakeInstallKnativeEventing())
 	command.AddCommand(apps.MakeInstallRedis())
+	command.AddCommand(apps.MakeInstallChart())
+	command.AddCommand(apps.MakeInstallCertManager())
 	command.AddCommand(apps.MakeWaitInstall())
 
 	command.AddCommand(MakeInfo())
@@ -60,6 +71,8 @@ command.`,
 
 func getApps() []string {
 	return []string{
+		"cert-manager",
+		"chart",
 		"cicd",
 		"fluentd",
 		"knative-eventing",
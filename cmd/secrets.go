@@ -0,0 +1,55 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+)
+
+func MakeSecrets() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "secrets [NAME]",
+		Short: "Print the generated credentials",
+		Long: `Print the credentials generated by the installs run with --generate-secrets,
+or only the value of the given one. They are read from the
+default/coolknative-secrets Secret, so only users allowed to read it can print
+them.`,
+		Example: `  coolknative secrets
+  coolknative secrets redis-password --context staging`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.Flags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		secrets, err := apps.GetGeneratedSecrets(command)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 1 {
+			value, ok := secrets[args[0]]
+			if !ok {
+				return fmt.Errorf("no secret named %s, one of %v", args[0], apps.SecretNames(secrets))
+			}
+			fmt.Println(value)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVALUE")
+		for _, name := range apps.SecretNames(secrets) {
+			fmt.Fprintf(w, "%s\t%s\n", name, secrets[name])
+		}
+		return w.Flush()
+	}
+
+	return command
+}
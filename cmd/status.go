@@ -0,0 +1,85 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+func MakeStatus() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "status",
+		Short: "Report the health of the installed components",
+		Long: `Report the health of the installed components without waiting for them.
+A component is ready when all its deployments, pods and custom resources are
+ready, degraded when some are missing or not ready, and missing when none of
+them exist. Exits with an error when a component is degraded, or missing
+while recorded as installed.`,
+		Example: `  coolknative status
+  coolknative status --output json --context staging`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.Flags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+	command.Flags().StringP("output", "o", "table", "Output format, table or json")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		output, _ := command.Flags().GetString("output")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("--output requires table or json, got %q", output)
+		}
+
+		statuses, err := apps.GetStatus(command)
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			out, err := json.MarshalIndent(statuses, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "COMPONENT\tSTATUS\tVERSION\tAGE\tMESSAGE")
+			for _, s := range statuses {
+				age := "-"
+				if s.Since != nil {
+					age = duration.HumanDuration(time.Since(*s.Since))
+				}
+				version := s.Version
+				if len(version) == 0 {
+					version = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Status, version, age, s.Message)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		unhealthy := 0
+		for _, s := range statuses {
+			if !s.Healthy() {
+				unhealthy++
+			}
+		}
+		if unhealthy > 0 {
+			return fmt.Errorf("%d components are unhealthy", unhealthy)
+		}
+
+		return nil
+	}
+
+	return command
+}
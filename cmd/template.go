@@ -0,0 +1,64 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+)
+
+func MakeTemplate() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "template",
+		Short: "Render the manifests of an app without installing it",
+		Long: `Render the manifests an app would apply, including the remote release
+manifests and the helm charts, without touching the cluster. Takes the same
+flags as the "install" command.`,
+		Example: `  coolknative template knative-serving --domain mydomain.com
+  coolknative template redis --namespace redis --output-dir ./rendered`,
+		SilenceUsage: false,
+	}
+
+	command.PersistentFlags().String("output-dir", "", "Write the manifests to NAME.yaml in this directory instead of stdout")
+	command.PersistentFlags().String("bundle", "", "Read the manifests, charts and helm from a bundle made with \"coolknative bundle create\" instead of the network")
+	command.PersistentFlags().String("lock-file", "coolknative.lock", "Pin the downloaded manifests and charts in this file, and fail when they change upstream, empty to disable")
+	command.PersistentFlags().Bool("dry-run", true, "")
+	command.PersistentFlags().MarkHidden("dry-run")
+
+	command.PersistentPostRunE = func(command *cobra.Command, args []string) error {
+		return apps.WriteRendered(command)
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			names := []string{}
+			for _, c := range command.Commands() {
+				names = append(names, c.Name())
+			}
+			fmt.Printf("You can template: %s\n%s\n\n", strings.TrimRight("\n - "+strings.Join(names, "\n - "), "\n - "),
+				`Run coolknative template NAME --help to see configuration options.`)
+			return nil
+		}
+
+		return nil
+	}
+
+	command.AddCommand(apps.MakeInstallTekton())
+	command.AddCommand(apps.MakeInstallCicd())
+	command.AddCommand(apps.MakeInstallNatsOperator())
+	command.AddCommand(apps.MakeInstallNatsStreamingOperator())
+	command.AddCommand(apps.MakeInstallNatsStreamingInstance())
+	command.AddCommand(apps.MakeInstallMinioOperator())
+	command.AddCommand(apps.MakeInstallMinioInstance())
+	command.AddCommand(apps.MakeInstallKnativeServing())
+	command.AddCommand(apps.MakeInstallKnativeEventing())
+	command.AddCommand(apps.MakeInstallRedis())
+	command.AddCommand(apps.MakeInstallChart())
+	command.AddCommand(apps.MakeInstallCertManager())
+
+	return command
+}
@@ -0,0 +1,179 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+func MakeTraffic() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "traffic",
+		Short: "Roll out the revisions of a Knative Service",
+		Long: `List the revisions of a Knative Service and change the traffic they receive:
+split it by percentage, tag revisions to reach them on TAG-NAME.DOMAIN, promote
+a tag to all the traffic, or roll back to the previous ready revision. Each
+change edits spec.traffic and waits for the route to converge. @latest
+designates the latest ready revision, which follows the deploys.`,
+		Example: `  coolknative traffic list api -n namespace1-api
+  coolknative traffic tag api candidate=@latest
+  coolknative traffic split api api-00001=90 @latest=10
+  coolknative traffic promote api candidate
+  coolknative traffic rollback api`,
+		Run: func(command *cobra.Command, args []string) {
+			command.Help()
+		},
+	}
+
+	command.PersistentFlags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.PersistentFlags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+	command.PersistentFlags().StringP("namespace", "n", "default", "Namespace of the service")
+	command.PersistentFlags().Duration("timeout", apps.DefaultDeployTimeout, "How long to wait for the route to converge")
+
+	command.AddCommand(makeTrafficList())
+	command.AddCommand(makeTrafficSplit())
+	command.AddCommand(makeTrafficTag())
+	command.AddCommand(makeTrafficUntag())
+	command.AddCommand(makeTrafficPromote())
+	command.AddCommand(makeTrafficRollback())
+
+	return command
+}
+
+func getTrafficFlags(command *cobra.Command) (string, time.Duration) {
+	namespace, _ := command.Flags().GetString("namespace")
+	timeout, _ := command.Flags().GetDuration("timeout")
+	return namespace, timeout
+}
+
+func makeTrafficList() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "list SERVICE",
+		Short:        "List the revisions of a service and their traffic",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, _ := getTrafficFlags(command)
+		revisions, err := apps.ListRevisions(command, namespace, args[0])
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REVISION\tREADY\tTRAFFIC\tTAGS\tAGE")
+		for _, r := range revisions {
+			name := r.Name
+			if r.Latest {
+				name += " (latest)"
+			}
+			tags := strings.Join(r.Tags, ",")
+			if len(tags) == 0 {
+				tags = "-"
+			}
+			fmt.Fprintf(w, "%s\t%t\t%d%%\t%s\t%s\n", name, r.Ready, r.Percent, tags, duration.HumanDuration(time.Since(r.Created)))
+		}
+		return w.Flush()
+	}
+
+	return command
+}
+
+func makeTrafficSplit() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "split SERVICE REVISION=PERCENT...",
+		Short:        "Split the traffic between revisions, the percents summing to 100",
+		Example:      `  coolknative traffic split api api-00001=90 @latest=10`,
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, timeout := getTrafficFlags(command)
+		return apps.SplitTraffic(command, namespace, args[0], args[1:], timeout)
+	}
+
+	return command
+}
+
+func makeTrafficTag() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "tag SERVICE TAG=REVISION...",
+		Short:        "Tag revisions, to reach them on TAG-NAME.DOMAIN",
+		Example:      `  coolknative traffic tag api candidate=@latest stable=api-00001`,
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, timeout := getTrafficFlags(command)
+		return apps.TagRevisions(command, namespace, args[0], args[1:], timeout)
+	}
+
+	return command
+}
+
+func makeTrafficUntag() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "untag SERVICE TAG...",
+		Short:        "Remove tags, the revisions keep their traffic",
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, timeout := getTrafficFlags(command)
+		return apps.UntagRevisions(command, namespace, args[0], args[1:], timeout)
+	}
+
+	return command
+}
+
+func makeTrafficPromote() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "promote SERVICE TAG",
+		Short:        "Route all the traffic to the revision of a tag",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, timeout := getTrafficFlags(command)
+		return apps.PromoteTag(command, namespace, args[0], args[1], timeout)
+	}
+
+	return command
+}
+
+func makeTrafficRollback() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "rollback SERVICE",
+		Short: "Route all the traffic to the previous ready revision",
+		Long: `Route all the traffic to the newest ready revision older than the newest one
+receiving traffic. The traffic then no longer follows the deploys, give it back
+with "traffic split SERVICE @latest=100".`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		namespace, timeout := getTrafficFlags(command)
+		revision, err := apps.RollbackService(command, namespace, args[0], timeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %s/%s to %s\n", namespace, args[0], revision)
+		return nil
+	}
+
+	return command
+}
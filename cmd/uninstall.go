@@ -0,0 +1,62 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eskersoftware/coolknative/cmd/apps"
+	"github.com/spf13/cobra"
+)
+
+func MakeUninstall() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Uninstall Kubernetes apps installed with coolknative",
+		Long: `Uninstall Kubernetes apps installed with the "install" command. Each app
+removes exactly the resources its installer created. CustomResourceDefinitions
+and PersistentVolumeClaims are deleted unless --keep-crds or --keep-pvcs is set.`,
+		Example: `  coolknative uninstall knative-eventing
+  coolknative uninstall minio-instance --keep-pvcs
+  coolknative uninstall tekton --keep-crds`,
+		SilenceUsage: false,
+	}
+
+	command.PersistentFlags().String("kubeconfig", "kubeconfig", "Local path for your kubeconfig file")
+	command.PersistentFlags().String("context", "", "Name of the kubeconfig context to use, the current context by default")
+	command.PersistentFlags().String("bundle", "", "Read the manifests, charts and helm from a bundle made with \"coolknative bundle create\" instead of the network")
+	command.PersistentFlags().String("lock-file", "coolknative.lock", "Pin the downloaded manifests and charts in this file, and fail when they change upstream, empty to disable")
+	command.PersistentFlags().Bool("keep-crds", false, "Keep the CustomResourceDefinitions and so the custom resources")
+	command.PersistentFlags().Bool("keep-pvcs", false, "Keep the PersistentVolumeClaims and so the stored data")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			names := []string{}
+			for _, c := range command.Commands() {
+				names = append(names, c.Name())
+			}
+			fmt.Printf("You can uninstall: %s\n%s\n\n", strings.TrimRight("\n - "+strings.Join(names, "\n - "), "\n - "),
+				`Run coolknative uninstall NAME --help to see configuration options.`)
+			return nil
+		}
+
+		return nil
+	}
+
+	command.AddCommand(apps.MakeUninstallTekton())
+	command.AddCommand(apps.MakeUninstallCicd())
+	command.AddCommand(apps.MakeUninstallNatsOperator())
+	command.AddCommand(apps.MakeUninstallNatsStreamingOperator())
+	command.AddCommand(apps.MakeUninstallNatsStreamingInstance())
+	command.AddCommand(apps.MakeUninstallMinioOperator())
+	command.AddCommand(apps.MakeUninstallMinioInstance())
+	command.AddCommand(apps.MakeUninstallKnativeServing())
+	command.AddCommand(apps.MakeUninstallKnativeEventing())
+	command.AddCommand(apps.MakeUninstallRedis())
+	command.AddCommand(apps.MakeUninstallChart())
+	command.AddCommand(apps.MakeUninstallCertManager())
+
+	return command
+}
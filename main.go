@@ -12,7 +12,15 @@ func main() {
 	cmdVersion := cmd.MakeVersion()
 	cmdInstall := cmd.MakeInstall()
 	cmdInfo := cmd.MakeInfo()
-
+	cmdApply := cmd.MakeApply()
+	cmdUninstall := cmd.MakeUninstall()
+	cmdTemplate := cmd.MakeTemplate()
+	cmdBundle := cmd.MakeBundle()
+	cmdStatus := cmd.MakeStatus()
+	cmdSecrets := cmd.MakeSecrets()
+	cmdConfig := cmd.MakeConfig()
+	cmdDeploy := cmd.MakeDeploy()
+	cmdTraffic := cmd.MakeTraffic()
 
 	var rootCmd = &cobra.Command{
 		Use: "coolknative",
@@ -22,8 +30,17 @@ func main() {
 	}
 
 	rootCmd.AddCommand(cmdInstall)
+	rootCmd.AddCommand(cmdUninstall)
+	rootCmd.AddCommand(cmdTemplate)
 	rootCmd.AddCommand(cmdVersion)
 	rootCmd.AddCommand(cmdInfo)
+	rootCmd.AddCommand(cmdApply)
+	rootCmd.AddCommand(cmdBundle)
+	rootCmd.AddCommand(cmdStatus)
+	rootCmd.AddCommand(cmdSecrets)
+	rootCmd.AddCommand(cmdConfig)
+	rootCmd.AddCommand(cmdDeploy)
+	rootCmd.AddCommand(cmdTraffic)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,196 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package bundle packs the artifacts fetched by the installers into a single
+// archive so they can run on clusters without internet access.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const indexName = "bundle.json"
+
+// Bundle is a set of artifacts, each identified by the key the installers use
+// to fetch it: a URL, or a kustomize or chart reference.
+type Bundle struct {
+	Version   string     `json:"version"`
+	Created   time.Time  `json:"created"`
+	Artifacts []Artifact `json:"artifacts"`
+
+	files map[string][]byte
+}
+
+// Artifact locates a file of the bundle and its checksum.
+type Artifact struct {
+	Key    string `json:"key"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// New creates an empty bundle for the given coolknative version.
+func New(version string) *Bundle {
+	return &Bundle{
+		Version: version,
+		Created: time.Now().UTC(),
+		files:   map[string][]byte{},
+	}
+}
+
+// Add stores an artifact, replacing any artifact with the same key.
+func (b *Bundle) Add(key string, data []byte) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	artifact := Artifact{
+		Key:    key,
+		Path:   path.Join("artifacts", digest[:12]+"-"+unsafeChars.ReplaceAllString(path.Base(key), "_")),
+		SHA256: digest,
+	}
+
+	for i, a := range b.Artifacts {
+		if a.Key == key {
+			b.Artifacts[i] = artifact
+			b.files[artifact.Path] = data
+			return
+		}
+	}
+	b.Artifacts = append(b.Artifacts, artifact)
+	b.files[artifact.Path] = data
+}
+
+// Get returns the content of an artifact.
+func (b *Bundle) Get(key string) ([]byte, error) {
+	for _, a := range b.Artifacts {
+		if a.Key == key {
+			return b.files[a.Path], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in bundle %s", key, b.Version)
+}
+
+// Write outputs the bundle as a gzip-compressed tarball.
+func (b *Bundle) Write(w io.Writer) error {
+	index, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	err = writeEntry(tw, indexName, index, b.Created)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for p := range b.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		err = writeEntry(tw, p, b.files[p], b.Created)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Open reads a bundle and verifies the checksum of every artifact.
+func Open(filename string) (*Bundle, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s requires a gzip-compressed tarball: %s", filename, err)
+	}
+
+	b := &Bundle{files: map[string][]byte{}}
+	var index []byte
+
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bundle %s: %s", filename, err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == indexName {
+			index = data
+			continue
+		}
+		b.files[header.Name] = data
+	}
+
+	if index == nil {
+		return nil, fmt.Errorf("bundle %s has no %s", filename, indexName)
+	}
+	if err := json.Unmarshal(index, b); err != nil {
+		return nil, fmt.Errorf("unable to read the index of bundle %s: %s", filename, err)
+	}
+
+	for _, a := range b.Artifacts {
+		data, ok := b.files[a.Path]
+		if !ok {
+			return nil, fmt.Errorf("bundle %s is missing %s", filename, a.Path)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != a.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s in bundle %s", a.Key, filename)
+		}
+	}
+
+	return b, nil
+}
+
+// Size returns the size in bytes of an artifact.
+func (b *Bundle) Size(a Artifact) int {
+	return len(b.files[a.Path])
+}
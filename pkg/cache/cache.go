@@ -0,0 +1,188 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package cache keeps the artifacts fetched by the installers on disk,
+// addressed by their SHA-256, and pins each of them in a lock file so re-runs
+// install exactly the same content.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of artifacts.
+const (
+	KindManifest = "manifest"
+	KindChart    = "chart"
+)
+
+// Entry pins an artifact in the lock file.
+type Entry struct {
+	// Key identifies the artifact like in a bundle: a URL, or a kustomize or
+	// chart reference.
+	Key     string `json:"key"`
+	Kind    string `json:"kind"`
+	URL     string `json:"url"`
+	Version string `json:"version,omitempty"`
+	SHA256  string `json:"sha256"`
+}
+
+// Lock is the content of a lock file.
+type Lock struct {
+	Artifacts []Entry `json:"artifacts"`
+}
+
+// Cache stores artifacts under a directory and pins them in a lock file.
+type Cache struct {
+	dir      string
+	lockFile string
+	lock     Lock
+}
+
+// Open returns the cache kept in dir, pinning the artifacts in lockFile. When
+// lockFile is empty, the artifacts are neither pinned, stored nor read from the
+// cache, as only a pin tells which content to read back.
+func Open(dir, lockFile string) (*Cache, error) {
+	err := os.MkdirAll(path.Join(dir, "sha256"), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{dir: dir, lockFile: lockFile}
+	if len(lockFile) == 0 {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.lock); err != nil {
+		return nil, fmt.Errorf("unable to read lock file %s: %s", lockFile, err)
+	}
+	return c, nil
+}
+
+// Locked returns the pinned entry of an artifact.
+func (c *Cache) Locked(key string) (Entry, bool) {
+	for _, e := range c.lock.Artifacts {
+		if e.Key == key {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Get returns the content of a pinned artifact when it is in the cache.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	e, ok := c.Locked(key)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(c.blob(e.SHA256))
+	if err != nil || digest(data) != e.SHA256 {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores the content of an artifact and pins it, the SHA-256 of entry is
+// computed. It fails when the artifact is pinned to another content, that is
+// when it changed upstream. Nothing is stored without a lock file.
+func (c *Cache) Put(entry Entry, data []byte) error {
+	if len(c.lockFile) == 0 {
+		return nil
+	}
+	entry.SHA256 = digest(data)
+
+	locked, ok := c.Locked(entry.Key)
+	if ok && locked.SHA256 != entry.SHA256 {
+		return fmt.Errorf("%s changed upstream: %s pins sha256 %s, got %s, remove it from the lock file to accept the new content",
+			entry.Key, c.lockFile, locked.SHA256, entry.SHA256)
+	}
+
+	err := writeFile(c.blob(entry.SHA256), data, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to cache %s: %s", entry.Key, err)
+	}
+
+	if ok {
+		return nil
+	}
+	c.lock.Artifacts = append(c.lock.Artifacts, entry)
+	return c.save()
+}
+
+// Fetch returns the content of an artifact from the cache when it is pinned,
+// else fetches it, stores it and pins it.
+func (c *Cache) Fetch(entry Entry, fetch func() ([]byte, error)) ([]byte, error) {
+	if data, ok := c.Get(entry.Key); ok {
+		return data, nil
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	return data, c.Put(entry, data)
+}
+
+func (c *Cache) blob(sum string) string {
+	return path.Join(c.dir, "sha256", sum)
+}
+
+// save writes the lock file, sorted so it can be kept under version control.
+func (c *Cache) save() error {
+	sort.Slice(c.lock.Artifacts, func(i, j int) bool {
+		return c.lock.Artifacts[i].Key < c.lock.Artifacts[j].Key
+	})
+
+	data, err := json.MarshalIndent(c.lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFile(c.lockFile, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write lock file %s: %s", c.lockFile, err)
+	}
+	return nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFile replaces a file at once, so an interrupted run leaves no partial
+// content behind.
+func writeFile(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchPinned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockFile := filepath.Join(dir, "coolknative.lock")
+
+	c, err := Open(filepath.Join(dir, "cache"), lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{Key: "https://example.com/serving.yaml", Kind: KindManifest, URL: "https://example.com/serving.yaml"}
+	_, err = c.Fetch(entry, func() ([]byte, error) { return []byte("v1"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A re-run reads the pinned content from the cache
+	c, err = Open(filepath.Join(dir, "cache"), lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Fetch(entry, func() ([]byte, error) {
+		t.Error("want the pinned content to be read from the cache")
+		return []byte("v2"), nil
+	})
+	if err != nil || string(data) != "v1" {
+		t.Errorf("want v1, got %q, %v", data, err)
+	}
+
+	err = c.Put(entry, []byte("v2"))
+	if err == nil || !strings.Contains(err.Error(), "changed upstream") {
+		t.Errorf("want an error for a changed artifact, got %v", err)
+	}
+}
+
+func TestPutWithoutLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Open(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Put(Entry{Key: "https://example.com/serving.yaml", Kind: KindManifest}, []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) > 0 {
+		t.Errorf("want nothing stored without a lock file, got %d blobs", len(blobs))
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package helm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// LocateChart returns the local path of a chart, a directory or an archive,
+// downloading it into dir unless it is already local. The chart is either:
+//   - a local directory or archive, used in place
+//   - an oci:// reference, pulled from the registry
+//   - the name of a chart of repoURL, an http(s):// or file:// repository
+//
+// The latest version is used when version is empty, it is ignored for local
+// charts.
+func (c *Client) LocateChart(chart, repoURL, version, dir string) (string, error) {
+	if IsLocalChart(chart) {
+		return LocalChartPath(chart), nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	if registry.IsOCI(chart) {
+		return c.pullOCIChart(chart, version, dir)
+	}
+
+	if len(repoURL) == 0 {
+		return "", fmt.Errorf("chart %s is neither local, an oci:// reference nor given a repository", chart)
+	}
+	return c.downloadRepoChart(chart, repoURL, version, dir)
+}
+
+func (c *Client) pullOCIChart(chart, version, dir string) (string, error) {
+	client, err := registry.NewClient(
+		registry.ClientOptCredentialsFile(c.settings.RegistryConfig),
+		registry.ClientOptWriter(ioutil.Discard),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	d := downloader.ChartDownloader{
+		Out:              os.Stdout,
+		Verify:           downloader.VerifyNever,
+		Getters:          c.getters(),
+		Options:          []getter.Option{getter.WithRegistryClient(client)},
+		RegistryClient:   client,
+		RepositoryConfig: c.settings.RepositoryConfig,
+		RepositoryCache:  c.settings.RepositoryCache,
+	}
+
+	filename, _, err := d.DownloadTo(chart, version, dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to pull %s: %s", chart, err)
+	}
+	return filename, nil
+}
+
+// downloadRepoChart downloads a chart from the index of its repository and
+// checks it against the digest of the index.
+func (c *Client) downloadRepoChart(chart, repoURL, version, dir string) (string, error) {
+	providers := c.getters()
+
+	// The index is cached under the hash of the URL of the repository
+	sum := sha256.Sum256([]byte(repoURL))
+	r, err := repo.NewChartRepository(&repo.Entry{Name: hex.EncodeToString(sum[:8]), URL: repoURL}, providers)
+	if err != nil {
+		return "", err
+	}
+	r.CachePath = c.settings.RepositoryCache
+
+	indexFile, err := r.DownloadIndexFile()
+	if err != nil {
+		return "", fmt.Errorf("unable to download the index of %s: %s", repoURL, err)
+	}
+	index, err := repo.LoadIndexFile(indexFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the index of %s: %s", repoURL, err)
+	}
+
+	cv, err := index.Get(chart, version)
+	if err != nil {
+		if len(version) > 0 {
+			return "", fmt.Errorf("chart %s version %s not found in %s", chart, version, repoURL)
+		}
+		return "", fmt.Errorf("chart %s not found in %s", chart, repoURL)
+	}
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart %s version %s of %s has no URL", chart, cv.Version, repoURL)
+	}
+
+	chartURL, err := repo.ResolveReferenceURL(repoURL, cv.URLs[0])
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(chartURL)
+	if err != nil {
+		return "", err
+	}
+	g, err := providers.ByScheme(u.Scheme)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := g.Get(chartURL, getter.WithURL(repoURL))
+	if err != nil {
+		return "", fmt.Errorf("unable to download %s: %s", chartURL, err)
+	}
+
+	if len(cv.Digest) > 0 {
+		sum := sha256.Sum256(data.Bytes())
+		if actual := hex.EncodeToString(sum[:]); actual != cv.Digest {
+			return "", fmt.Errorf("digest mismatch for %s: expected %s, got %s", chartURL, cv.Digest, actual)
+		}
+	}
+
+	filename := path.Join(dir, fmt.Sprintf("%s-%s.tgz", cv.Name, cv.Version))
+	return filename, ioutil.WriteFile(filename, data.Bytes(), 0600)
+}
+
+// getters returns the getters of the http(s):// and oci:// URLs, along with
+// one of file:// URLs for repositories mirrored on a local filesystem.
+func (c *Client) getters() getter.Providers {
+	return append(getter.All(c.settings), getter.Provider{
+		Schemes: []string{"file"},
+		New: func(options ...getter.Option) (getter.Getter, error) {
+			return fileGetter{}, nil
+		},
+	})
+}
+
+// fileGetter reads file:// URLs.
+type fileGetter struct{}
+
+func (fileGetter) Get(href string, options ...getter.Option) (*bytes.Buffer, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) > 0 && u.Host != "localhost" {
+		return nil, fmt.Errorf("%s is not a local file", href)
+	}
+
+	data, err := ioutil.ReadFile(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(data), nil
+}
+
+// ChartVersion returns the version of a local chart, a directory or an
+// archive.
+func ChartVersion(chartPath string) (string, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to load chart %s: %s", chartPath, err)
+	}
+	return chart.Metadata.Version, nil
+}
+
+// IsLocalChart tells whether a chart reference is a local directory or
+// archive. It must be written as a path, starting with ./, ../ or /, or as a
+// file:// URL, so that a chart name never picks up a directory of the working
+// directory.
+func IsLocalChart(chart string) bool {
+	for _, prefix := range []string{"./", "../", "/", "file://"} {
+		if strings.HasPrefix(chart, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalChartPath returns the path of a local chart reference.
+func LocalChartPath(chart string) string {
+	return strings.TrimPrefix(chart, "file://")
+}
@@ -0,0 +1,206 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package helm
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// installTimeout bounds the wait for the resources of a release to be ready.
+const installTimeout = 10 * time.Minute
+
+// Client manages chart repositories and releases in-process with the helm
+// SDK. The repositories are kept under its home directory, apart from the
+// ones of a helm CLI.
+type Client struct {
+	settings *cli.EnvSettings
+}
+
+// New returns a client for the given kubeconfig and context, the current one
+// when empty, keeping its repositories under home.
+func New(kubeconfig, context, home string) *Client {
+	settings := cli.New()
+	settings.KubeConfig = kubeconfig
+	settings.KubeContext = context
+	settings.RepositoryConfig = path.Join(home, "repositories.yaml")
+	settings.RepositoryCache = path.Join(home, "repository")
+	settings.RegistryConfig = path.Join(home, "registry", "config.json")
+	return &Client{settings: settings}
+}
+
+// ValueOptions are the values given to a chart on top of its defaults, like
+// the --values, --set, --set-string and --set-file flags of helm.
+type ValueOptions struct {
+	// ValueFiles are local YAML files, merged in order
+	ValueFiles []string
+	// Values are key=value pairs, the values being typed
+	Values []string
+	// StringValues are key=value pairs, the values being kept as strings
+	StringValues []string
+	// FileValues are key=path pairs, the values being read from the files
+	FileValues []string
+}
+
+// Merge returns the values of the options, the pairs taking precedence over
+// the files and the later files over the earlier ones.
+func (o ValueOptions) Merge() (map[string]interface{}, error) {
+	opts := values.Options{
+		ValueFiles:   o.ValueFiles,
+		Values:       o.Values,
+		StringValues: o.StringValues,
+		FileValues:   o.FileValues,
+	}
+
+	// Without getters, the files can only be local
+	merged, err := opts.MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the chart values: %s", err)
+	}
+	return merged, nil
+}
+
+// Template renders a local chart, a directory or an archive, like Upgrade
+// would install it, without contacting the cluster.
+func (c *Client) Template(releaseName, chartPath, namespace string, values map[string]interface{}) ([]byte, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load chart %s: %s", chartPath, err)
+	}
+
+	install := action.NewInstall(&action.Configuration{Log: log.Printf})
+	install.ReleaseName = releaseName
+	install.Namespace = namespace
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+
+	rel, err := install.Run(chart, values)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := rel.Manifest
+	for _, hook := range rel.Hooks {
+		manifest += "\n---\n# Source: " + hook.Path + "\n" + hook.Manifest
+	}
+	return []byte(manifest), nil
+}
+
+// Upgrade installs or upgrades a release of a local chart, a directory or an
+// archive, waiting for its resources to be ready when wait is set. The values
+// are merged over the ones stored with the installed release, unless
+// resetValues is set, so the release keeps its configuration across upgrades.
+func (c *Client) Upgrade(releaseName, chartPath, namespace string, values map[string]interface{}, resetValues, wait bool) (*release.Release, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load chart %s: %s", chartPath, err)
+	}
+
+	cfg, err := c.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := cfg.Releases.History(releaseName)
+	if err == driver.ErrReleaseNotFound || (err == nil && uninstalled(history)) {
+		install := action.NewInstall(cfg)
+		install.ReleaseName = releaseName
+		install.Namespace = namespace
+		install.Wait = wait
+		install.Timeout = installTimeout
+		// A release uninstalled with --keep-history is replaced
+		install.Replace = true
+		return install.Run(chart, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = namespace
+	upgrade.Wait = wait
+	upgrade.Timeout = installTimeout
+	upgrade.ResetValues = resetValues
+	upgrade.ResetThenReuseValues = !resetValues
+	return upgrade.Run(releaseName, chart, values)
+}
+
+// Uninstall removes a release, it is not an error when there is none.
+func (c *Client) Uninstall(releaseName, namespace string) error {
+	cfg, err := c.configuration(namespace)
+	if err != nil {
+		return err
+	}
+
+	uninstall := action.NewUninstall(cfg)
+	uninstall.IgnoreNotFound = true
+	_, err = uninstall.Run(releaseName)
+	return err
+}
+
+// Release returns the latest revision of a release, looked up in every
+// namespace when namespace is empty, nil when there is none or it is
+// uninstalled.
+func (c *Client) Release(releaseName, namespace string) (*release.Release, error) {
+	cfg, err := c.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(namespace) > 0 {
+		rel, err := action.NewStatus(cfg).Run(releaseName)
+		if err == driver.ErrReleaseNotFound || (err == nil && rel.Info.Status == release.StatusUninstalled) {
+			return nil, nil
+		}
+		return rel, err
+	}
+
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	list.All = true
+	list.StateMask = action.ListAll &^ action.ListUninstalled
+	list.Filter = "^" + regexp.QuoteMeta(releaseName) + "$"
+	releases, err := list.Run()
+	if err != nil || len(releases) == 0 {
+		return nil, err
+	}
+	return releases[0], nil
+}
+
+// configuration returns an action configuration storing the releases in
+// Secrets of the namespace, like the helm CLI.
+func (c *Client) configuration(namespace string) (*action.Configuration, error) {
+	c.settings.SetNamespace(namespace)
+	cfg := &action.Configuration{}
+	err := cfg.Init(c.settings.RESTClientGetter(), namespace, "secret", log.Printf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure helm: %s", err)
+	}
+	return cfg, nil
+}
+
+func uninstalled(history []*release.Release) bool {
+	if len(history) == 0 {
+		return true
+	}
+	last := history[0]
+	for _, r := range history {
+		if r.Version > last.Version {
+			last = r
+		}
+	}
+	return last.Info.Status == release.StatusUninstalled
+}
@@ -3,15 +3,22 @@
 // license that can be found in the LICENSE file.
 
 // Edited on 2019-10-11 to remove support for nested folders when un-taring
-// so that all files are placed in the same target directory
+// so that all files are placed in the same target directory.
+// Edited again to make the flattening optional, to read zip and plain tar
+// archives, to extract links which stay within the target directory and to
+// select the members to extract.
 
 package helm
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -20,99 +27,339 @@ import (
 	"time"
 )
 
-// TODO(bradfitz): this was copied from x/build/cmd/buildlet/buildlet.go
-// but there were some buildlet-specific bits in there, so the code is
-// forked for now.  Unfork and add some opts arguments here, so the
-// buildlet can use this code somehow.
+// ExtractOptions select how an archive is extracted.
+type ExtractOptions struct {
+	// KeepDirs keeps the directory structure of the archive, otherwise every
+	// file is written directly into the target directory and links are
+	// skipped.
+	KeepDirs bool
+	// Members restricts the extraction to the files with these paths in the
+	// archive, or these base names when KeepDirs is not set. Every member must
+	// be found.
+	Members []string
+}
 
-// Untar reads the gzip-compressed tar file from r and writes it into dir.
+// Untar reads the gzip-compressed tar file from r and writes its files
+// directly into dir.
 func Untar(r io.Reader, dir string) error {
-	return untar(r, dir)
+	return Extract(r, dir, ExtractOptions{})
 }
 
-func untar(r io.Reader, dir string) (err error) {
-	t0 := time.Now()
-	nFiles := 0
-	madeDir := map[string]bool{}
+// Extract reads a gzip-compressed tar, tar or zip archive from r and writes
+// it into dir. Entries and links escaping dir are rejected.
+func Extract(r io.Reader, dir string, opts ExtractOptions) (err error) {
+	x := &extractor{
+		dir:     dir,
+		opts:    opts,
+		t0:      time.Now(),
+		madeDir: map[string]bool{},
+		found:   map[string]bool{},
+	}
 	defer func() {
-		td := time.Since(t0)
+		td := time.Since(x.t0)
 		if err == nil {
-			log.Printf("extracted tarball into %s: %d files, %d dirs (%v)", dir, nFiles, len(madeDir), td)
+			log.Printf("extracted archive into %s: %d files, %d dirs (%v)", dir, x.nFiles, len(x.madeDir), td)
 		} else {
-			log.Printf("error extracting tarball into %s after %d files, %d dirs, %v: %v", dir, nFiles, len(madeDir), td, err)
+			log.Printf("error extracting archive into %s after %d files, %d dirs, %v: %v", dir, x.nFiles, len(x.madeDir), td, err)
 		}
 	}()
-	zr, err := gzip.NewReader(r)
-	if err != nil {
-		return fmt.Errorf("requires gzip-compressed body: %v", err)
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("invalid gzip-compressed body: %v", err)
+		}
+		err = x.untar(tar.NewReader(zr))
+		if err != nil {
+			return err
+		}
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("zip error: %v", err)
+		}
+		err = x.unzip(zr)
+		if err != nil {
+			return err
+		}
+	default:
+		err = x.untar(tar.NewReader(br))
+		if err != nil {
+			return err
+		}
 	}
-	tr := tar.NewReader(zr)
-	loggedChtimesError := false
+
+	for _, member := range opts.Members {
+		if !x.found[member] {
+			return fmt.Errorf("%s not found in archive", member)
+		}
+	}
+	return nil
+}
+
+type extractor struct {
+	dir     string
+	opts    ExtractOptions
+	t0      time.Time
+	nFiles  int
+	madeDir map[string]bool
+	found   map[string]bool
+	// loggedChtimesError is set once a Chtimes error has been logged
+	loggedChtimesError bool
+}
+
+func (x *extractor) untar(tr *tar.Reader) error {
 	for {
 		f, err := tr.Next()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			log.Printf("tar reading error: %v", err)
 			return fmt.Errorf("tar error: %v", err)
 		}
-		if !validRelPath(f.Name) {
-			return fmt.Errorf("tar contained invalid name error %q", f.Name)
+
+		switch f.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(f.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.writeFile(f.Name, f.FileInfo().Mode(), f.ModTime, f.Size, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(f.Name, f.Linkname)
+		case tar.TypeLink:
+			err = x.link(f.Name, f.Linkname)
+		default:
+			// Devices, fifos and pax headers are not extracted
+			if !validRelPath(f.Name) {
+				err = fmt.Errorf("tar contained invalid name error %q", f.Name)
+			}
+		}
+		if err != nil {
+			return err
 		}
-		baseFile := filepath.Base(f.Name)
-		abs := path.Join(dir, baseFile)
-		fmt.Println(abs, f.Name)
+	}
+}
 
-		fi := f.FileInfo()
-		mode := fi.Mode()
+func (x *extractor) unzip(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		mode := f.Mode()
 		switch {
 		case mode.IsDir():
-
-			break
-
-		case mode.IsRegular():
-
-			wf, err := os.OpenFile(abs, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
-			if err != nil {
+			if err := x.mkdir(f.Name); err != nil {
 				return err
 			}
-			n, err := io.Copy(wf, tr)
-			if closeErr := wf.Close(); closeErr != nil && err == nil {
-				err = closeErr
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return err
 			}
+			target, err := ioutil.ReadAll(rc)
+			rc.Close()
 			if err != nil {
-				return fmt.Errorf("error writing to %s: %v", abs, err)
+				return err
 			}
-			if n != f.Size {
-				return fmt.Errorf("only wrote %d bytes to %s; expected %d", n, abs, f.Size)
+			if err := x.symlink(f.Name, string(target)); err != nil {
+				return err
 			}
-			modTime := f.ModTime
-			if modTime.After(t0) {
-				// Clamp modtimes at system time. See
-				// golang.org/issue/19062 when clock on
-				// buildlet was behind the gitmirror server
-				// doing the git-archive.
-				modTime = t0
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
 			}
-			if !modTime.IsZero() {
-				if err := os.Chtimes(abs, modTime, modTime); err != nil && !loggedChtimesError {
-					// benign error. Gerrit doesn't even set the
-					// modtime in these, and we don't end up relying
-					// on it anywhere (the gomote push command relies
-					// on digests only), so this is a little pointless
-					// for now.
-					log.Printf("error changing modtime: %v (further Chtimes errors suppressed)", err)
-					loggedChtimesError = true // once is enough
-				}
+			err = x.writeFile(f.Name, mode, f.Modified, int64(f.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return err
 			}
-			nFiles++
-		default:
 		}
 	}
 	return nil
 }
 
+// target returns where an entry is written and whether it is extracted.
+func (x *extractor) target(name string) (string, bool, error) {
+	if !validRelPath(name) {
+		return "", false, fmt.Errorf("archive contained invalid name error %q", name)
+	}
+
+	member := path.Clean(name)
+	if !x.opts.KeepDirs {
+		member = path.Base(member)
+	}
+	if len(x.opts.Members) > 0 {
+		wanted := false
+		for _, m := range x.opts.Members {
+			if m == member {
+				wanted = true
+			}
+		}
+		if !wanted {
+			return "", false, nil
+		}
+	}
+	x.found[member] = true
+
+	if err := x.checkParents(member); err != nil {
+		return "", false, err
+	}
+	return filepath.Join(x.dir, filepath.FromSlash(member)), true, nil
+}
+
+// checkParents rejects entries under a symlink, which could lead out of the
+// target directory.
+func (x *extractor) checkParents(member string) error {
+	for parent := path.Dir(member); parent != "."; parent = path.Dir(parent) {
+		fi, err := os.Lstat(filepath.Join(x.dir, filepath.FromSlash(parent)))
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive contained %q under the symlink %q", member, parent)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(name string) error {
+	// The target directory itself, as in archives of "."
+	if path.Clean(name) == "." && !strings.HasPrefix(name, "/") {
+		return nil
+	}
+	if !validRelPath(name) {
+		return fmt.Errorf("archive contained invalid name error %q", name)
+	}
+	// Directories are created along with the files selected as members
+	if !x.opts.KeepDirs || len(x.opts.Members) > 0 {
+		return nil
+	}
+	member := path.Clean(name)
+	if err := x.checkParents(member); err != nil {
+		return err
+	}
+	return x.mkdirAll(filepath.Join(x.dir, filepath.FromSlash(member)))
+}
+
+func (x *extractor) mkdirAll(abs string) error {
+	if x.madeDir[abs] {
+		return nil
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return err
+	}
+	x.madeDir[abs] = true
+	return nil
+}
+
+func (x *extractor) writeFile(name string, mode os.FileMode, modTime time.Time, size int64, r io.Reader) error {
+	abs, ok, err := x.target(name)
+	if err != nil || !ok {
+		return err
+	}
+	if err := x.mkdirAll(filepath.Dir(abs)); err != nil {
+		return err
+	}
+
+	// A link of a previous entry is replaced rather than written through
+	if fi, err := os.Lstat(abs); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(abs); err != nil {
+			return err
+		}
+	}
+
+	wf, err := os.OpenFile(abs, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(wf, r)
+	if closeErr := wf.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing to %s: %v", abs, err)
+	}
+	if n != size {
+		return fmt.Errorf("only wrote %d bytes to %s; expected %d", n, abs, size)
+	}
+	if modTime.After(x.t0) {
+		// Clamp modtimes at system time. See
+		// golang.org/issue/19062 when clock on
+		// buildlet was behind the gitmirror server
+		// doing the git-archive.
+		modTime = x.t0
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(abs, modTime, modTime); err != nil && !x.loggedChtimesError {
+			// benign error, the extracted files are only
+			// checked through their content
+			log.Printf("error changing modtime: %v (further Chtimes errors suppressed)", err)
+			x.loggedChtimesError = true // once is enough
+		}
+	}
+	x.nFiles++
+	return nil
+}
+
+// symlink creates a symbolic link, its target must stay within the target
+// directory. It may only go up with leading ".." so that following other
+// links of the archive cannot lead out of it.
+func (x *extractor) symlink(name, linkname string) error {
+	parts := strings.Split(linkname, "/")
+	ups := 0
+	for ups < len(parts) && parts[ups] == ".." {
+		ups++
+	}
+	escapes := linkname == "" || path.IsAbs(linkname) || strings.Contains(linkname, `\`) ||
+		ups > strings.Count(path.Clean(name), "/")
+	for _, part := range parts[ups:] {
+		if part == ".." {
+			escapes = true
+		}
+	}
+	if escapes {
+		return fmt.Errorf("archive contained symlink %q escaping the target directory to %q", name, linkname)
+	}
+
+	abs, ok, err := x.target(name)
+	if err != nil || !ok || !x.opts.KeepDirs {
+		return err
+	}
+	if err := x.mkdirAll(filepath.Dir(abs)); err != nil {
+		return err
+	}
+	os.Remove(abs)
+	return os.Symlink(linkname, abs)
+}
+
+// link creates a hard link, its target is a previous entry of the archive.
+func (x *extractor) link(name, linkname string) error {
+	if !validRelPath(linkname) || !validRelativeDir(linkname) {
+		return fmt.Errorf("archive contained hard link %q escaping the target directory to %q", name, linkname)
+	}
+
+	abs, ok, err := x.target(name)
+	if err != nil || !ok || !x.opts.KeepDirs {
+		return err
+	}
+	if err := x.mkdirAll(filepath.Dir(abs)); err != nil {
+		return err
+	}
+
+	source := filepath.Join(x.dir, filepath.FromSlash(path.Clean(linkname)))
+	fi, err := os.Lstat(source)
+	if err != nil {
+		return fmt.Errorf("hard link %q to %q: %v", name, linkname, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("hard link %q to %q which is not a regular file", name, linkname)
+	}
+	os.Remove(abs)
+	return os.Link(source, abs)
+}
+
 func validRelativeDir(dir string) bool {
 	if strings.Contains(dir, `\`) || path.IsAbs(dir) {
 		return false
@@ -124,9 +371,12 @@ func validRelativeDir(dir string) bool {
 	return true
 }
 
+// validRelPath tells whether an entry name designates a path strictly within
+// the target directory.
 func validRelPath(p string) bool {
-	if p == "" || strings.Contains(p, `\`) || strings.HasPrefix(p, "/") || strings.Contains(p, "../") {
+	if p == "" || strings.Contains(p, `\`) || strings.HasPrefix(p, "/") {
 		return false
 	}
-	return true
+	clean := path.Clean(p)
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
 }
@@ -0,0 +1,226 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package helm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is a member of a test archive, a symlink or a hard link when link is
+// set.
+type entry struct {
+	name     string
+	body     string
+	link     string
+	typeflag byte
+}
+
+func file(name, body string) entry {
+	return entry{name: name, body: body, typeflag: tar.TypeReg}
+}
+
+func dir(name string) entry {
+	return entry{name: name, typeflag: tar.TypeDir}
+}
+
+func symlink(name, target string) entry {
+	return entry{name: name, link: target, typeflag: tar.TypeSymlink}
+}
+
+func hardlink(name, target string) entry {
+	return entry{name: name, link: target, typeflag: tar.TypeLink}
+}
+
+func tarball(t *testing.T, entries ...entry) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extract(t *testing.T, archive []byte, opts ExtractOptions) (string, error) {
+	root, err := ioutil.TempDir("", "untar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	target := filepath.Join(root, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return target, Extract(bytes.NewReader(archive), target, opts)
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExtractKeepDirs(t *testing.T) {
+	archive := tarball(t,
+		dir("./"),
+		dir("redis/"),
+		file("redis/Chart.yaml", "name: redis"),
+		file("redis/templates/NOTES.txt", "notes"),
+		file("redis/charts/common/Chart.yaml", "name: common"),
+		symlink("redis/README", "Chart.yaml"),
+		hardlink("redis/Chart.copy", "redis/Chart.yaml"),
+	)
+
+	target, err := extract(t, archive, ExtractOptions{KeepDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"redis/Chart.yaml":               "name: redis",
+		"redis/templates/NOTES.txt":      "notes",
+		"redis/charts/common/Chart.yaml": "name: common",
+		"redis/README":                   "name: redis",
+		"redis/Chart.copy":               "name: redis",
+	} {
+		if got := readFile(t, filepath.Join(target, name)); got != want {
+			t.Errorf("%s: want %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestExtractFlattens(t *testing.T) {
+	archive := tarball(t,
+		file("helm-v3/linux-amd64/helm", "binary"),
+		symlink("helm-v3/linux-amd64/link", "helm"),
+	)
+
+	target, err := extract(t, archive, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(target, "helm")); got != "binary" {
+		t.Errorf("want the file in the target directory, got %q", got)
+	}
+	if _, err := os.Lstat(filepath.Join(target, "link")); !os.IsNotExist(err) {
+		t.Errorf("want links skipped when flattening, got %v", err)
+	}
+}
+
+func TestExtractMembers(t *testing.T) {
+	archive := tarball(t,
+		file("linux-amd64/helm", "binary"),
+		file("linux-amd64/LICENSE", "license"),
+	)
+
+	target, err := extract(t, archive, ExtractOptions{Members: []string{"helm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "LICENSE")); !os.IsNotExist(err) {
+		t.Errorf("want only the members extracted, got %v", err)
+	}
+
+	_, err = extract(t, archive, ExtractOptions{Members: []string{"tiller"}})
+	if err == nil {
+		t.Error("want an error for a missing member")
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("windows-amd64/helm.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("binary"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := extract(t, buf.Bytes(), ExtractOptions{KeepDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(target, "windows-amd64", "helm.exe")); got != "binary" {
+		t.Errorf("want the zip member extracted, got %q", got)
+	}
+}
+
+func TestExtractRejectsTraversal(t *testing.T) {
+	for name, archive := range map[string][]byte{
+		"parent file":              tarball(t, file("../evil", "x")),
+		"nested parent file":       tarball(t, file("a/../../evil", "x")),
+		"absolute file":            tarball(t, file("/tmp/evil", "x")),
+		"backslash file":           tarball(t, file(`a\..\evil`, "x")),
+		"parent directory":         tarball(t, dir("..")),
+		"parent directory entry":   tarball(t, dir("a/../..")),
+		"symlink named ..":         tarball(t, symlink("..", "a")),
+		"symlink named a/..":       tarball(t, symlink("a/..", "b")),
+		"symlink named a/.. in a":  tarball(t, dir("a/"), symlink("a/..", "b")),
+		"symlink named .":          tarball(t, symlink(".", "a")),
+		"symlink to parent":        tarball(t, symlink("evil", "../outside")),
+		"symlink to absolute path": tarball(t, symlink("evil", "/etc/passwd")),
+		"symlink going back up":    tarball(t, symlink("a/evil", "../b/../../outside")),
+		"hard link to parent":      tarball(t, hardlink("evil", "../outside")),
+		"file under a symlink":     tarball(t, dir("a/"), symlink("link", "a"), file("link/evil", "x")),
+	} {
+		target, err := extract(t, archive, ExtractOptions{KeepDirs: true})
+		if err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+
+		entries, _ := ioutil.ReadDir(filepath.Dir(target))
+		if len(entries) != 1 {
+			t.Errorf("%s: want nothing written beside the target directory, got %d entries", name, len(entries))
+		}
+		if fi, err := os.Lstat(target); err != nil || !fi.IsDir() {
+			t.Errorf("%s: want the target directory kept, got %v", name, err)
+		}
+	}
+}
+
+func TestExtractReplacesSymlink(t *testing.T) {
+	archive := tarball(t,
+		file("a", "target"),
+		symlink("b", "a"),
+		file("b", "replaced"),
+	)
+
+	target, err := extract(t, archive, ExtractOptions{KeepDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(target, "a")); got != "target" {
+		t.Errorf("want the file behind the link untouched, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(target, "b")); got != "replaced" {
+		t.Errorf("want the link replaced, got %q", got)
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package k8s talks to the Kubernetes API server in place of kubectl.
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// FieldManager owns the fields written by server-side apply.
+const FieldManager = "coolknative"
+
+// Object identifies a resource in the cluster. Namespace is empty for
+// cluster-scoped resources.
+type Object struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+func (o Object) String() string {
+	if len(o.Namespace) == 0 {
+		return fmt.Sprintf("%s %s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// ReadyFunc tells whether a resource reached the state waited for.
+type ReadyFunc func(obj *unstructured.Unstructured) (bool, error)
+
+// Client is the set of cluster operations used by the installers.
+type Client interface {
+	// Apply server-side applies every document of a YAML manifest. Documents
+	// without a namespace are placed in namespace when they are namespaced.
+	Apply(ctx context.Context, manifest []byte, namespace string) error
+	// Create creates every document of a YAML manifest, which allows the use
+	// of metadata.generateName.
+	Create(ctx context.Context, manifest []byte, namespace string) error
+	// Delete deletes every document of a YAML manifest, ignoring the ones
+	// which are already gone, and returns the deleted ones.
+	Delete(ctx context.Context, manifest []byte, namespace string) ([]Object, error)
+	Get(ctx context.Context, obj Object) (*unstructured.Unstructured, error)
+	List(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) ([]unstructured.Unstructured, error)
+	Patch(ctx context.Context, obj Object, patchType types.PatchType, patch []byte) error
+	// DeleteObject deletes a single resource, ignoring it when it is already gone.
+	DeleteObject(ctx context.Context, obj Object) error
+	DeleteCollection(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) error
+	// Wait polls a resource until ready returns true or ctx is done.
+	Wait(ctx context.Context, obj Object, ready ReadyFunc) error
+}
+
+// ResourceError reports the resource on which an operation failed, the
+// underlying error can be checked with the k8s.io/apimachinery/pkg/api/errors
+// helpers.
+type ResourceError struct {
+	Operation string
+	Object    Object
+	Err       error
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("unable to %s %s: %s", e.Operation, e.Object, e.Err)
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+type client struct {
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+}
+
+// New creates a Client from a kubeconfig file and context. When kubeconfig is
+// empty $KUBECONFIG, ~/.kube/config and then the in-cluster configuration are
+// used, when kubeContext is empty the current context is used.
+func New(kubeconfig, kubeContext string) (Client, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %s", err)
+	}
+
+	return NewForConfig(config)
+}
+
+func NewForConfig(config *rest.Config) (Client, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	return NewForClients(dynamicClient, mapper), nil
+}
+
+// NewForClients creates a Client on top of existing clients, for instance the
+// fake dynamic client of k8s.io/client-go/dynamic/fake.
+func NewForClients(dynamicClient dynamic.Interface, mapper meta.RESTMapper) Client {
+	return &client{
+		dynamic: dynamicClient,
+		mapper:  mapper,
+	}
+}
+
+// Decode splits a YAML manifest into its documents, skipping empty ones.
+func Decode(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	objects := []*unstructured.Unstructured{}
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode manifest: %s", err)
+		}
+		// A document with only blank lines or comments decodes to nothing
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+
+		// Numbers are decoded as int64 when they are integers, like the API does
+		obj := &unstructured.Unstructured{}
+		err = utiljson.Unmarshal(raw, &obj.Object)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode manifest: %s", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.IsList() {
+			err = obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+func (c *client) Apply(ctx context.Context, manifest []byte, namespace string) error {
+	objects, err := Decode(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		resource, ref, err := c.resourceFor(ctx, obj, namespace, true)
+		if err != nil {
+			return &ResourceError{Operation: "apply", Object: ref, Err: err}
+		}
+
+		_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return &ResourceError{Operation: "apply", Object: ref, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (c *client) Create(ctx context.Context, manifest []byte, namespace string) error {
+	objects, err := Decode(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		resource, ref, err := c.resourceFor(ctx, obj, namespace, true)
+		if err != nil {
+			return &ResourceError{Operation: "create", Object: ref, Err: err}
+		}
+
+		_, err = resource.Create(ctx, obj, metav1.CreateOptions{FieldManager: FieldManager})
+		if err != nil {
+			return &ResourceError{Operation: "create", Object: ref, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (c *client) Delete(ctx context.Context, manifest []byte, namespace string) ([]Object, error) {
+	objects, err := Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []Object{}
+	for i := len(objects) - 1; i >= 0; i-- {
+		resource, ref, err := c.resourceFor(ctx, objects[i], namespace, false)
+		if meta.IsNoMatchError(err) {
+			// The type is gone with its CustomResourceDefinition, so are its resources
+			continue
+		}
+		if err != nil {
+			return deleted, &ResourceError{Operation: "delete", Object: ref, Err: err}
+		}
+
+		found, err := deleteIgnoreNotFound(ctx, resource, ref.Name)
+		if err != nil {
+			return deleted, &ResourceError{Operation: "delete", Object: ref, Err: err}
+		}
+		if found {
+			deleted = append(deleted, ref)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (c *client) Get(ctx context.Context, obj Object) (*unstructured.Unstructured, error) {
+	resource, err := c.resource(obj.APIVersion, obj.Kind, obj.Namespace)
+	if err != nil {
+		return nil, &ResourceError{Operation: "get", Object: obj, Err: err}
+	}
+
+	res, err := resource.Get(ctx, obj.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, &ResourceError{Operation: "get", Object: obj, Err: err}
+	}
+	return res, nil
+}
+
+func (c *client) List(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) ([]unstructured.Unstructured, error) {
+	ref := Object{APIVersion: apiVersion, Kind: kind, Namespace: namespace}
+
+	resource, err := c.resource(apiVersion, kind, namespace)
+	if err != nil {
+		return nil, &ResourceError{Operation: "list", Object: ref, Err: err}
+	}
+
+	list, err := resource.List(ctx, opts)
+	if err != nil {
+		return nil, &ResourceError{Operation: "list", Object: ref, Err: err}
+	}
+	return list.Items, nil
+}
+
+func (c *client) Patch(ctx context.Context, obj Object, patchType types.PatchType, patch []byte) error {
+	resource, err := c.resource(obj.APIVersion, obj.Kind, obj.Namespace)
+	if err != nil {
+		return &ResourceError{Operation: "patch", Object: obj, Err: err}
+	}
+
+	_, err = resource.Patch(ctx, obj.Name, patchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return &ResourceError{Operation: "patch", Object: obj, Err: err}
+	}
+	return nil
+}
+
+func (c *client) DeleteObject(ctx context.Context, obj Object) error {
+	resource, err := c.resource(obj.APIVersion, obj.Kind, obj.Namespace)
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return &ResourceError{Operation: "delete", Object: obj, Err: err}
+	}
+
+	_, err = deleteIgnoreNotFound(ctx, resource, obj.Name)
+	if err != nil {
+		return &ResourceError{Operation: "delete", Object: obj, Err: err}
+	}
+	return nil
+}
+
+func (c *client) DeleteCollection(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) error {
+	ref := Object{APIVersion: apiVersion, Kind: kind, Namespace: namespace}
+
+	resource, err := c.resource(apiVersion, kind, namespace)
+	if err != nil {
+		return &ResourceError{Operation: "delete", Object: ref, Err: err}
+	}
+
+	err = resource.DeleteCollection(ctx, metav1.DeleteOptions{}, opts)
+	if err != nil {
+		return &ResourceError{Operation: "delete", Object: ref, Err: err}
+	}
+	return nil
+}
+
+func (c *client) Wait(ctx context.Context, obj Object, ready ReadyFunc) error {
+	var lastErr error
+	err := wait.PollUntilContextCancel(ctx, 2*time.Second, true, func(ctx context.Context) (bool, error) {
+		res, err := c.Get(ctx, obj)
+		if err != nil {
+			// The resource may not be created yet by its operator
+			lastErr = err
+			return false, nil
+		}
+		return ready(res)
+	})
+	if err != nil {
+		if lastErr != nil {
+			err = lastErr
+		}
+		return &ResourceError{Operation: "wait for", Object: obj, Err: err}
+	}
+	return nil
+}
+
+// establishTimeout bounds how long a document waits for the
+// CustomResourceDefinition of its type, applied just before, to be served.
+const establishTimeout = 30 * time.Second
+
+// resourceFor finds the resource of a decoded document. When establish is set
+// it retries while the CustomResourceDefinition of its type is being established.
+func (c *client) resourceFor(ctx context.Context, obj *unstructured.Unstructured, namespace string, establish bool) (dynamic.ResourceInterface, Object, error) {
+	ref := Object{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+	if len(ref.Namespace) == 0 {
+		ref.Namespace = namespace
+	}
+
+	var mapping *meta.RESTMapping
+	err := wait.PollUntilContextTimeout(ctx, time.Second, establishTimeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		mapping, err = c.mapping(ref.APIVersion, ref.Kind)
+		if meta.IsNoMatchError(err) && establish {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		// Report the mapping error rather than the timeout
+		if _, mappingErr := c.mapping(ref.APIVersion, ref.Kind); mappingErr != nil {
+			err = mappingErr
+		}
+		return nil, ref, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		ref.Namespace = ""
+		return c.dynamic.Resource(mapping.Resource), ref, nil
+	}
+
+	if len(ref.Namespace) == 0 {
+		ref.Namespace = metav1.NamespaceDefault
+	}
+	obj.SetNamespace(ref.Namespace)
+
+	return c.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace), ref, nil
+}
+
+func (c *client) resource(apiVersion, kind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := c.mapping(apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace || len(namespace) == 0 {
+		return c.dynamic.Resource(mapping.Resource), nil
+	}
+	return c.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// mapping finds the resource of a type. The discovery is refreshed when the
+// type is unknown, its CustomResourceDefinition may have been created since.
+func (c *client) mapping(apiVersion, kind string) (*meta.RESTMapping, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	gk := schema.GroupKind{Group: gv.Group, Kind: kind}
+
+	mapping, err := c.mapper.RESTMapping(gk, gv.Version)
+	if meta.IsNoMatchError(err) {
+		if resettable, ok := c.mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = c.mapper.RESTMapping(gk, gv.Version)
+		}
+	}
+	return mapping, err
+}
+
+// deleteIgnoreNotFound tells whether the resource existed and was deleted.
+func deleteIgnoreNotFound(ctx context.Context, resource dynamic.ResourceInterface, name string) (bool, error) {
+	propagation := metav1.DeletePropagationBackground
+	err := resource.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReplicasReady waits for a StatefulSet, or any resource reporting its ready
+// replicas the same way, to have all its replicas ready for its latest spec.
+func ReplicasReady(obj *unstructured.Unstructured) (bool, error) {
+	replicas, found, err := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		replicas = 1
+	}
+
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observed < obj.GetGeneration() {
+		return false, nil
+	}
+
+	ready, _, err := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	return ready >= replicas, err
+}
+
+// ConditionTrue waits for a status condition, compared case-insensitively like
+// "kubectl wait --for=condition=...".
+func ConditionTrue(conditionType string) ReadyFunc {
+	return func(obj *unstructured.Unstructured) (bool, error) {
+		conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		if err != nil {
+			return false, err
+		}
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(fmt.Sprint(condition["type"]), conditionType) {
+				return fmt.Sprint(condition["status"]) == string(metav1.ConditionTrue), nil
+			}
+		}
+		return false, nil
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+var (
+	configMapKind   = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	namespaceKind   = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+	natsClusterKind = schema.GroupVersionKind{Group: "nats.io", Version: "v1alpha2", Kind: "NatsCluster"}
+)
+
+// discoveryMapper serves the types known at the last Reset, like the
+// DeferredDiscoveryRESTMapper over a cluster where CustomResourceDefinitions
+// are registered.
+type discoveryMapper struct {
+	*meta.DefaultRESTMapper
+	registered []schema.GroupVersionKind
+	resets     int
+}
+
+func newDiscoveryMapper() *discoveryMapper {
+	m := &discoveryMapper{}
+	m.registered = []schema.GroupVersionKind{configMapKind}
+	m.Reset()
+	m.resets = 0
+	return m
+}
+
+func (m *discoveryMapper) register(gvk schema.GroupVersionKind) {
+	m.registered = append(m.registered, gvk)
+}
+
+func (m *discoveryMapper) Reset() {
+	m.resets++
+	m.DefaultRESTMapper = meta.NewDefaultRESTMapper(nil)
+	m.DefaultRESTMapper.Add(namespaceKind, meta.RESTScopeRoot)
+	for _, gvk := range m.registered {
+		m.DefaultRESTMapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+}
+
+func newFakeClient(mapper meta.RESTMapper, objects ...runtime.Object) Client {
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}:                           "ConfigMapList",
+		{Version: "v1", Resource: "namespaces"}:                           "NamespaceList",
+		{Group: "nats.io", Version: "v1alpha2", Resource: "natsclusters"}: "NatsClusterList",
+	}
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	return NewForClients(dynamicClient, mapper)
+}
+
+func natsCluster(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(natsClusterKind)
+	obj.SetNamespace("nats")
+	obj.SetName(name)
+	unstructured.SetNestedField(obj.Object, int64(3), "spec", "size")
+	return obj
+}
+
+func TestDecode(t *testing.T) {
+	manifest := `
+---
+# Only a comment
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: minio
+---
+
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: first
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: second
+`
+	objects, err := Decode([]byte(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, obj := range objects {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	want := []string{"Namespace/minio", "ConfigMap/first", "ConfigMap/second"}
+	if len(names) != len(want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, names)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	_, err := Decode([]byte("apiVersion: v1\nkind: [ConfigMap\n"))
+	if err == nil {
+		t.Fatal("want an error for an invalid manifest")
+	}
+}
+
+func TestApplyNamespaces(t *testing.T) {
+	client := newFakeClient(newDiscoveryMapper())
+
+	manifest := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: minio
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: defaulted
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: explicit
+  namespace: other
+`
+	err := client.Create(context.Background(), []byte(manifest), "minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, obj := range []Object{
+		{APIVersion: "v1", Kind: "Namespace", Name: "minio"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "minio", Name: "defaulted"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "other", Name: "explicit"},
+	} {
+		if _, err := client.Get(context.Background(), obj); err != nil {
+			t.Errorf("want %s: %s", obj, err)
+		}
+	}
+}
+
+func TestDeleteIgnoresMissing(t *testing.T) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(configMapKind)
+	existing.SetNamespace("nats")
+	existing.SetName("existing")
+	client := newFakeClient(newDiscoveryMapper(), existing)
+
+	manifest := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: existing
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: gone
+---
+apiVersion: nats.io/v1alpha2
+kind: NatsCluster
+metadata:
+  name: unknown-type
+`
+	deleted, err := client.Delete(context.Background(), []byte(manifest), "nats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: "nats", Name: "existing"}
+	if len(deleted) != 1 || deleted[0] != want {
+		t.Fatalf("want only %s deleted, got %v", want, deleted)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	client := newFakeClient(newDiscoveryMapper())
+
+	_, err := client.Get(context.Background(), Object{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "missing"})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("want a not found error, got %v", err)
+	}
+}
+
+// The client is created before the CustomResourceDefinition of NatsCluster is
+// applied, as by "install nats-operator" followed by "install nats-instance".
+func TestRegisteredAfterStart(t *testing.T) {
+	mapper := newDiscoveryMapper()
+	client := newFakeClient(mapper, natsCluster("existing"))
+	mapper.register(natsClusterKind)
+
+	obj := Object{APIVersion: "nats.io/v1alpha2", Kind: "NatsCluster", Namespace: "nats", Name: "existing"}
+	if _, err := client.Get(context.Background(), obj); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if mapper.resets != 1 {
+		t.Fatalf("want the discovery refreshed once, got %d", mapper.resets)
+	}
+
+	items, err := client.List(context.Background(), "nats.io/v1alpha2", "NatsCluster", "nats", metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("want 1 NatsCluster, got %d", len(items))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Wait(ctx, obj, func(obj *unstructured.Unstructured) (bool, error) {
+		size, _, _ := unstructured.NestedInt64(obj.Object, "spec", "size")
+		return size == 3, nil
+	})
+	if err != nil {
+		t.Fatalf("Wait: %s", err)
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	client := newFakeClient(newDiscoveryMapper())
+
+	_, err := client.Get(context.Background(), Object{APIVersion: "nats.io/v1alpha2", Kind: "NatsCluster", Namespace: "nats", Name: "missing"})
+	if !meta.IsNoMatchError(err) {
+		t.Fatalf("want a no match error, got %v", err)
+	}
+}
+
+func TestConditionTrue(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+				map[string]interface{}{"type": "Succeeded", "status": "False"},
+			},
+		},
+	}}
+
+	for condition, want := range map[string]bool{"ready": true, "Succeeded": false, "Missing": false} {
+		ready, err := ConditionTrue(condition)(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready != want {
+			t.Errorf("condition %s: want %t, got %t", condition, want, ready)
+		}
+	}
+}
+
+func TestReplicasReady(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": int64(3)},
+		"status":   map[string]interface{}{"observedGeneration": int64(1), "readyReplicas": int64(3)},
+	}}
+
+	ready, _ := ReplicasReady(obj)
+	if ready {
+		t.Fatal("want not ready while the latest spec is not observed")
+	}
+
+	unstructured.SetNestedField(obj.Object, int64(2), "status", "observedGeneration")
+	ready, _ = ReplicasReady(obj)
+	if !ready {
+		t.Fatal("want ready")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// clusterScoped lists the kinds which never get a namespace when rendered.
+var clusterScoped = map[string]bool{
+	"APIService":                     true,
+	"ClusterIssuer":                  true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"PersistentVolume":               true,
+	"PriorityClass":                  true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// Renderer is a Client which never contacts a cluster. It records the
+// resources it is asked to apply or create, applies patches to them, and
+// writes them out as a multi-document YAML manifest. It is safe for
+// concurrent use.
+type Renderer struct {
+	mu      sync.Mutex
+	objects []*unstructured.Unstructured
+}
+
+func NewRenderer() *Renderer {
+	return &Renderer{}
+}
+
+// Write outputs the recorded resources in the order they were first applied.
+func (r *Renderer) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, obj := range r.objects {
+		out, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Renderer) Apply(ctx context.Context, manifest []byte, namespace string) error {
+	objects, err := Decode(manifest)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, obj := range objects {
+		if len(obj.GetNamespace()) == 0 && len(namespace) > 0 && !clusterScoped[obj.GetKind()] {
+			obj.SetNamespace(namespace)
+		}
+		if i := r.find(refOf(obj)); i >= 0 {
+			r.objects[i] = obj
+			continue
+		}
+		r.objects = append(r.objects, obj)
+	}
+	return nil
+}
+
+func (r *Renderer) Create(ctx context.Context, manifest []byte, namespace string) error {
+	return r.Apply(ctx, manifest, namespace)
+}
+
+// Delete does nothing, a rendered manifest only describes what is installed.
+func (r *Renderer) Delete(ctx context.Context, manifest []byte, namespace string) ([]Object, error) {
+	return nil, nil
+}
+
+func (r *Renderer) Get(ctx context.Context, obj Object) (*unstructured.Unstructured, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.find(obj)
+	if i < 0 {
+		return nil, &ResourceError{Operation: "get", Object: obj, Err: notFound(obj)}
+	}
+	return r.objects[i].DeepCopy(), nil
+}
+
+func (r *Renderer) List(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) ([]unstructured.Unstructured, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := []unstructured.Unstructured{}
+	for _, obj := range r.objects {
+		if obj.GetAPIVersion() != apiVersion || obj.GetKind() != kind {
+			continue
+		}
+		if len(namespace) > 0 && obj.GetNamespace() != namespace {
+			continue
+		}
+		if !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		items = append(items, *obj.DeepCopy())
+	}
+	return items, nil
+}
+
+// Patch applies the patch to the recorded resource. Strategic merge patches
+// need the resource to be a built-in type, others fall back on a JSON merge
+// patch.
+func (r *Renderer) Patch(ctx context.Context, obj Object, patchType types.PatchType, patch []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.find(obj)
+	if i < 0 {
+		return &ResourceError{Operation: "patch", Object: obj, Err: notFound(obj)}
+	}
+
+	original, err := json.Marshal(r.objects[i].Object)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	typed, typedErr := scheme.Scheme.New(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+	switch {
+	case patchType == types.StrategicMergePatchType && typedErr == nil:
+		patched, err = strategicpatch.StrategicMergePatch(original, patch, typed)
+	case patchType == types.StrategicMergePatchType || patchType == types.MergePatchType:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case patchType == types.JSONPatchType:
+		var p jsonpatch.Patch
+		p, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = p.Apply(original)
+		}
+	default:
+		err = fmt.Errorf("unsupported patch type %s", patchType)
+	}
+	if err != nil {
+		return &ResourceError{Operation: "patch", Object: obj, Err: err}
+	}
+
+	res := &unstructured.Unstructured{}
+	if err := utiljson.Unmarshal(patched, &res.Object); err != nil {
+		return err
+	}
+	r.objects[i] = res
+	return nil
+}
+
+func (r *Renderer) DeleteObject(ctx context.Context, obj Object) error {
+	return nil
+}
+
+func (r *Renderer) DeleteCollection(ctx context.Context, apiVersion, kind, namespace string, opts metav1.ListOptions) error {
+	return nil
+}
+
+// Wait returns at once as if the resource was ready, so that installers go on
+// rendering what they apply next.
+func (r *Renderer) Wait(ctx context.Context, obj Object, ready ReadyFunc) error {
+	return nil
+}
+
+func (r *Renderer) find(obj Object) int {
+	for i, o := range r.objects {
+		if refOf(o) == obj {
+			return i
+		}
+	}
+	return -1
+}
+
+func refOf(obj *unstructured.Unstructured) Object {
+	name := obj.GetName()
+	if len(name) == 0 {
+		name = obj.GetGenerateName()
+	}
+	return Object{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       name,
+	}
+}
+
+func notFound(obj Object) error {
+	gv, _ := schema.ParseGroupVersion(obj.APIVersion)
+	return apierrors.NewNotFound(gv.WithResource(obj.Kind).GroupResource(), obj.Name)
+}
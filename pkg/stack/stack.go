@@ -0,0 +1,248 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const APIVersion = "coolknative/v1alpha1"
+const Kind = "Stack"
+
+// Stack describes the components of a platform and the settings used to
+// install each of them.
+type Stack struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+	Spec       Spec     `json:"spec"`
+}
+
+type Metadata struct {
+	Name string `json:"name"`
+}
+
+type Spec struct {
+	Components []Component `json:"components"`
+}
+
+// Component is one installer, named as its "coolknative install" sub-command.
+// Settings are the installer's flags without the leading dashes. Release is
+// the helm release of a chart component, a stack can hold several of them.
+type Component struct {
+	Name     string                 `json:"name"`
+	Release  string                 `json:"release,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// Key identifies a component in a stack: its name or, for a chart, the key its
+// version is recorded under by "install chart".
+func (c Component) Key() string {
+	if len(c.Release) == 0 {
+		return c.Name
+	}
+	namespace, _ := c.Settings["namespace"].(string)
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+	return c.Name + "." + namespace + "." + c.Release
+}
+
+// dependencies lists which components must be installed before a given one
+// when both are part of the same stack. cicd creates the minio namespace and
+// the credentials secret used by the minio Tenant. knative-serving issues its
+// certificate with cert-manager when --tls-issuer is given.
+var dependencies = map[string][]string{
+	"cert-manager":            {},
+	"chart":                   {},
+	"cicd":                    {"tekton"},
+	"knative-serving":         {"cert-manager"},
+	"knative-eventing":        {"nats-streaming-instance"},
+	"minio-operator":          {},
+	"minio-instance":          {"minio-operator", "cicd"},
+	"nats-operator":           {},
+	"nats-streaming-operator": {"nats-operator"},
+	"nats-streaming-instance": {"nats-operator", "nats-streaming-operator"},
+	"redis":                   {},
+	"tekton":                  {},
+}
+
+// Components returns the names of the components a stack can declare.
+func Components() []string {
+	names := []string{}
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envReference matches the ${VAR} references expanded in the settings, a
+// lone $ is kept as it may be part of a password.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Load reads and validates a stack file. Environment variables written
+// ${DOCKER_PASSWORD} are expanded in the settings so secrets do not need to be
+// committed.
+func Load(filename string) (*Stack, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range s.Spec.Components {
+		for k, v := range c.Settings {
+			c.Settings[k] = expandEnv(v)
+		}
+	}
+	return s, nil
+}
+
+// expandEnv expands the environment variables of the string values of a
+// setting.
+func expandEnv(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+		})
+	case []interface{}:
+		expanded := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			expanded = append(expanded, expandEnv(item))
+		}
+		return expanded
+	}
+	return value
+}
+
+func Parse(data []byte) (*Stack, error) {
+	s := &Stack{}
+	if err := yaml.UnmarshalStrict(data, s); err != nil {
+		return nil, fmt.Errorf("unable to parse stack: %s", err)
+	}
+
+	if s.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q, expected %q", s.APIVersion, APIVersion)
+	}
+	if s.Kind != Kind {
+		return nil, fmt.Errorf("unsupported kind %q, expected %q", s.Kind, Kind)
+	}
+
+	seen := map[string]bool{}
+	for i := range s.Spec.Components {
+		c := &s.Spec.Components[i]
+		if _, ok := dependencies[c.Name]; !ok {
+			return nil, fmt.Errorf("unknown component %q, valid components are: %s", c.Name, strings.Join(Components(), ", "))
+		}
+
+		if c.Name == "chart" {
+			// The release may also be given as the flag of "install chart"
+			releaseName, _ := c.Settings["release-name"].(string)
+			if len(c.Release) > 0 && len(releaseName) > 0 && releaseName != c.Release {
+				return nil, fmt.Errorf("chart %q has a different release-name setting %q", c.Release, releaseName)
+			}
+			if len(c.Release) == 0 {
+				c.Release = releaseName
+			}
+			if len(c.Release) == 0 {
+				return nil, fmt.Errorf("component chart requires a release")
+			}
+		} else if len(c.Release) > 0 {
+			return nil, fmt.Errorf("component %q takes no release, only chart does", c.Name)
+		}
+
+		if seen[c.Key()] {
+			return nil, fmt.Errorf("component %q is declared more than once", c.Key())
+		}
+		seen[c.Key()] = true
+	}
+
+	return s, nil
+}
+
+// Ordered returns the components sorted so that every component comes after
+// its dependencies, otherwise keeping the order of the stack file.
+func (s *Stack) Ordered() ([]Component, error) {
+	// pending counts the components of each name left to order
+	pending := map[string]int{}
+	for _, c := range s.Spec.Components {
+		pending[c.Name]++
+	}
+
+	done := map[string]bool{}
+	ordered := []Component{}
+	for len(ordered) < len(s.Spec.Components) {
+		progress := false
+		for _, c := range s.Spec.Components {
+			if done[c.Key()] {
+				continue
+			}
+			ready := true
+			for _, dep := range dependencies[c.Name] {
+				if pending[dep] > 0 {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[c.Key()] = true
+				pending[c.Name]--
+				ordered = append(ordered, c)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("circular dependency between components")
+		}
+	}
+
+	return ordered, nil
+}
+
+// Flags converts the settings of a component into command-line flags.
+// Lists are turned into a repeated flag.
+func (c Component) Flags() ([]string, error) {
+	keys := []string{}
+	for k := range c.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	flags := []string{}
+	if _, ok := c.Settings["release-name"]; len(c.Release) > 0 && !ok {
+		flags = append(flags, "--release-name="+c.Release)
+	}
+	for _, k := range keys {
+		values := []interface{}{c.Settings[k]}
+		if list, ok := c.Settings[k].([]interface{}); ok {
+			values = list
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case string, bool:
+				flags = append(flags, fmt.Sprintf("--%s=%v", k, v))
+			case float64:
+				flags = append(flags, fmt.Sprintf("--%s=%s", k, strconv.FormatFloat(v, 'f', -1, 64)))
+			default:
+				return nil, fmt.Errorf("setting %q of component %q must be a string, number, boolean or list", k, c.Name)
+			}
+		}
+	}
+
+	return flags, nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package stack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stackOf(components string) []byte {
+	return []byte("apiVersion: coolknative/v1alpha1\nkind: Stack\nmetadata:\n  name: test\nspec:\n  components:\n" + components)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		data []byte
+		want string
+	}{
+		"api version": {[]byte("apiVersion: v1\nkind: Stack\n"), "unsupported apiVersion"},
+		"kind":        {[]byte("apiVersion: coolknative/v1alpha1\nkind: List\n"), "unsupported kind"},
+		"unknown field": {
+			stackOf("  - name: redis\n    setting: {}\n"), "unable to parse stack",
+		},
+		"unknown component": {
+			stackOf("  - name: mongodb\n"), `unknown component "mongodb"`,
+		},
+		"duplicate component": {
+			stackOf("  - name: redis\n  - name: redis\n"), `component "redis" is declared more than once`,
+		},
+		"chart without release": {
+			stackOf("  - name: chart\n"), "component chart requires a release",
+		},
+		"conflicting release": {
+			stackOf("  - name: chart\n    release: ingress\n    settings:\n      release-name: other\n"), "different release-name",
+		},
+		"release of another component": {
+			stackOf("  - name: redis\n    release: cache\n"), "takes no release",
+		},
+		"duplicate chart release": {
+			stackOf("  - name: chart\n    release: ingress\n  - name: chart\n    settings:\n      release-name: ingress\n"),
+			`component "chart.default.ingress" is declared more than once`,
+		},
+	} {
+		_, err := Parse(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: want an error containing %q, got %v", name, test.want, err)
+		}
+	}
+}
+
+func TestParseCharts(t *testing.T) {
+	s, err := Parse(stackOf(`  - name: chart
+    release: ingress
+    settings:
+      namespace: ingress-nginx
+  - name: chart
+    settings:
+      release-name: monitoring
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for _, c := range s.Spec.Components {
+		keys = append(keys, c.Key())
+	}
+	want := []string{"chart.ingress-nginx.ingress", "chart.default.monitoring"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("want keys %v, got %v", want, keys)
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	for _, test := range []struct {
+		components string
+		want       []string
+	}{
+		{
+			"  - name: minio-instance\n  - name: cicd\n  - name: minio-operator\n  - name: tekton\n",
+			[]string{"minio-operator", "tekton", "cicd", "minio-instance"},
+		},
+		{
+			"  - name: knative-serving\n  - name: redis\n  - name: cert-manager\n",
+			[]string{"redis", "cert-manager", "knative-serving"},
+		},
+		{
+			"  - name: nats-streaming-instance\n  - name: nats-streaming-operator\n  - name: nats-operator\n",
+			[]string{"nats-operator", "nats-streaming-operator", "nats-streaming-instance"},
+		},
+		{
+			"  - name: chart\n    release: a\n  - name: redis\n  - name: chart\n    release: b\n",
+			[]string{"chart.default.a", "redis", "chart.default.b"},
+		},
+	} {
+		s, err := Parse(stackOf(test.components))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ordered, err := s.Ordered()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []string{}
+		for _, c := range ordered {
+			keys = append(keys, c.Key())
+		}
+		if !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("want %v, got %v", test.want, keys)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for _, test := range []struct {
+		component Component
+		want      []string
+	}{
+		{
+			Component{Name: "cicd", Settings: map[string]interface{}{
+				"docker-username":      "me",
+				"generate-secrets":     true,
+				"skaffold-application": []interface{}{"api:namespace1-api", "front:namespace1-front"},
+			}},
+			[]string{"--docker-username=me", "--generate-secrets=true",
+				"--skaffold-application=api:namespace1-api", "--skaffold-application=front:namespace1-front"},
+		},
+		{
+			Component{Name: "chart", Settings: map[string]interface{}{"replicas": float64(3), "timeout": 0.5, "big": float64(12345678)}},
+			[]string{"--big=12345678", "--replicas=3", "--timeout=0.5"},
+		},
+		{
+			Component{Name: "chart", Release: "ingress", Settings: map[string]interface{}{"namespace": "ingress-nginx"}},
+			[]string{"--release-name=ingress", "--namespace=ingress-nginx"},
+		},
+	} {
+		flags, err := test.component.Flags()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(flags, test.want) {
+			t.Errorf("want %v, got %v", test.want, flags)
+		}
+	}
+
+	_, err := Component{Name: "redis", Settings: map[string]interface{}{"set": map[string]interface{}{}}}.Flags()
+	if err == nil {
+		t.Error("want an error for a map setting")
+	}
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	os.Setenv("STACK_TEST_USERNAME", "me")
+	os.Setenv("STACK_TEST_PASSWORD", "s3cr=t")
+	defer os.Unsetenv("STACK_TEST_USERNAME")
+	defer os.Unsetenv("STACK_TEST_PASSWORD")
+
+	dir, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "stack.yaml")
+	data := stackOf(`  - name: cicd
+    settings:
+      docker-username: ${STACK_TEST_USERNAME}
+      docker-password: pa$$word-${STACK_TEST_PASSWORD}
+      add-application-namespace:
+      - ${STACK_TEST_USERNAME}-api
+      - $STACK_TEST_USERNAME
+`)
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"docker-username":           "me",
+		"docker-password":           "pa$$word-s3cr=t",
+		"add-application-namespace": []interface{}{"me-api", "$STACK_TEST_USERNAME"},
+	}
+	if got := s.Spec.Components[0].Settings; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package versions holds the compatibility matrix of the components which
+// coolknative installs from upstream releases.
+package versions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Release is a version of a component which is known to work, with the
+// versions of the parts released separately but installed with it.
+type Release struct {
+	Version string
+	Parts   map[string]string
+}
+
+// Component lists the releases of a component, newest first. The first one is
+// installed by default.
+type Component struct {
+	Name     string
+	Releases []Release
+	// PartsFollowVersion is set when the parts are released in lockstep with
+	// the component, so an untested version uses parts of the same version.
+	PartsFollowVersion bool
+	// SameMinor lists the components which must run the same minor version
+	// when they are installed together.
+	SameMinor []string
+}
+
+var matrix = []Component{
+	{
+		Name: "knative-serving",
+		Releases: []Release{
+			{Version: "v0.18.0", Parts: map[string]string{"net-kourier": "v0.18.0", "net-contour": "v0.18.0", "net-istio": "v0.18.0"}},
+			{Version: "v0.17.0", Parts: map[string]string{"net-kourier": "v0.17.0", "net-contour": "v0.17.0", "net-istio": "v0.17.0"}},
+			{Version: "v0.16.0", Parts: map[string]string{"net-kourier": "v0.16.0", "net-contour": "v0.16.0", "net-istio": "v0.16.0"}},
+		},
+		PartsFollowVersion: true,
+		SameMinor:          []string{"knative-eventing"},
+	},
+	{
+		Name: "knative-eventing",
+		Releases: []Release{
+			{Version: "v0.18.0", Parts: map[string]string{"eventing-natss": "v0.18.0"}},
+			{Version: "v0.17.0", Parts: map[string]string{"eventing-natss": "v0.17.0"}},
+			{Version: "v0.16.0", Parts: map[string]string{"eventing-natss": "v0.16.0"}},
+		},
+		PartsFollowVersion: true,
+		SameMinor:          []string{"knative-serving"},
+	},
+	{
+		Name: "tekton",
+		Releases: []Release{
+			{Version: "v0.16.3", Parts: map[string]string{"tekton-dashboard": "v0.9.0"}},
+			{Version: "v0.15.2", Parts: map[string]string{"tekton-dashboard": "v0.9.0"}},
+			{Version: "v0.14.3", Parts: map[string]string{"tekton-dashboard": "v0.8.2"}},
+		},
+	},
+	{
+		Name: "cert-manager",
+		Releases: []Release{
+			{Version: "v1.0.4"},
+			{Version: "v1.0.3"},
+		},
+	},
+	{
+		Name: "nats-operator",
+		Releases: []Release{
+			{Version: "v0.7.2"},
+		},
+	},
+	{
+		Name: "nats-streaming-operator",
+		Releases: []Release{
+			{Version: "v0.3.0"},
+		},
+	},
+	{
+		Name: "minio-operator",
+		Releases: []Release{
+			{Version: "v3.0.29"},
+			{Version: "v3.0.28"},
+		},
+	},
+	{
+		// Versions of the bitnami chart
+		Name: "redis",
+		Releases: []Release{
+			{Version: "12.0.0"},
+			{Version: "11.3.4"},
+		},
+	},
+}
+
+var minorVersion = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// Components returns the components of the matrix.
+func Components() []Component {
+	return matrix
+}
+
+// Get returns a component of the matrix.
+func Get(name string) (Component, error) {
+	for _, c := range matrix {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Component{}, fmt.Errorf("%s has no compatibility matrix", name)
+}
+
+// Default returns the release installed when no version is given.
+func (c Component) Default() Release {
+	return c.Releases[0]
+}
+
+// Supported returns the versions of the matrix.
+func (c Component) Supported() []string {
+	supported := []string{}
+	for _, r := range c.Releases {
+		supported = append(supported, r.Version)
+	}
+	return supported
+}
+
+// Release returns the release of a version from the matrix. Unless force is
+// set, versions missing from the matrix are refused. Otherwise their parts
+// follow the version, or are those of the default release.
+func (c Component) Release(version string, force bool) (Release, error) {
+	if len(version) == 0 {
+		return c.Default(), nil
+	}
+	// Chart versions have no v prefix
+	if strings.HasPrefix(c.Default().Version, "v") && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	for _, r := range c.Releases {
+		if r.Version == version {
+			return r, nil
+		}
+	}
+
+	if !force {
+		return Release{}, fmt.Errorf("%s %s is not in the compatibility matrix, supported versions are %s",
+			c.Name, version, strings.Join(c.Supported(), ", "))
+	}
+
+	release := Release{Version: version, Parts: map[string]string{}}
+	for part, partVersion := range c.Default().Parts {
+		if c.PartsFollowVersion {
+			partVersion = version
+		}
+		release.Parts[part] = partVersion
+	}
+	return release, nil
+}
+
+// Check verifies a version against the versions of the installed components,
+// given by name.
+func (c Component) Check(version string, installed map[string]string) error {
+	for _, other := range c.SameMinor {
+		otherVersion, ok := installed[other]
+		if !ok {
+			continue
+		}
+		if Minor(otherVersion) != Minor(version) {
+			return fmt.Errorf("%s %s requires %s %s.x, %s is installed",
+				c.Name, version, other, Minor(version), otherVersion)
+		}
+	}
+	return nil
+}
+
+// Minor returns the major and minor parts of a version, like "v0.18".
+func Minor(version string) string {
+	match := minorVersion.FindStringSubmatch(version)
+	if match == nil {
+		return version
+	}
+	return fmt.Sprintf("v%s.%s", match[1], match[2])
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Simon Rey 2020. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+package versions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	c, err := Get("tekton")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Default().Version != "v0.16.3" {
+		t.Errorf("want default v0.16.3, got %s", c.Default().Version)
+	}
+
+	_, err = Get("mongodb")
+	if err == nil || !strings.Contains(err.Error(), "has no compatibility matrix") {
+		t.Errorf("want an error for an unknown component, got %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	for name, test := range map[string]struct {
+		component string
+		version   string
+		force     bool
+		want      Release
+		wantErr   string
+	}{
+		"default": {
+			component: "tekton",
+			want:      Release{Version: "v0.16.3", Parts: map[string]string{"tekton-dashboard": "v0.9.0"}},
+		},
+		"listed": {
+			component: "tekton", version: "v0.14.3",
+			want: Release{Version: "v0.14.3", Parts: map[string]string{"tekton-dashboard": "v0.8.2"}},
+		},
+		"missing v prefix": {
+			component: "knative-eventing", version: "0.17.0",
+			want: Release{Version: "v0.17.0", Parts: map[string]string{"eventing-natss": "v0.17.0"}},
+		},
+		"chart version": {
+			component: "redis", version: "11.3.4",
+			want: Release{Version: "11.3.4"},
+		},
+		"unlisted": {
+			component: "tekton", version: "v0.17.0",
+			wantErr: "tekton v0.17.0 is not in the compatibility matrix, supported versions are v0.16.3, v0.15.2, v0.14.3",
+		},
+		"forced parts follow version": {
+			component: "knative-serving", version: "v0.19.0", force: true,
+			want: Release{Version: "v0.19.0", Parts: map[string]string{"net-kourier": "v0.19.0", "net-contour": "v0.19.0", "net-istio": "v0.19.0"}},
+		},
+		"forced default parts": {
+			component: "tekton", version: "v0.17.0", force: true,
+			want: Release{Version: "v0.17.0", Parts: map[string]string{"tekton-dashboard": "v0.9.0"}},
+		},
+		"forced without parts": {
+			component: "cert-manager", version: "v1.1.0", force: true,
+			want: Release{Version: "v1.1.0", Parts: map[string]string{}},
+		},
+	} {
+		c, err := Get(test.component)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		release, err := c.Release(test.version, test.force)
+		if len(test.wantErr) > 0 {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: want error %q, got %v", name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(release, test.want) {
+			t.Errorf("%s: want %v, got %v", name, test.want, release)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for name, test := range map[string]struct {
+		component string
+		version   string
+		installed map[string]string
+		wantErr   string
+	}{
+		"nothing installed": {
+			component: "knative-serving", version: "v0.18.0",
+		},
+		"same minor": {
+			component: "knative-serving", version: "v0.18.0",
+			installed: map[string]string{"knative-eventing": "v0.18.2"},
+		},
+		"other minor": {
+			component: "knative-serving", version: "v0.18.0",
+			installed: map[string]string{"knative-eventing": "v0.17.0"},
+			wantErr:   "knative-serving v0.18.0 requires knative-eventing v0.18.x, v0.17.0 is installed",
+		},
+		"unrelated component": {
+			component: "tekton", version: "v0.16.3",
+			installed: map[string]string{"knative-eventing": "v0.17.0"},
+		},
+	} {
+		c, err := Get(test.component)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.Check(test.version, test.installed)
+		if len(test.wantErr) == 0 && err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if len(test.wantErr) > 0 && (err == nil || err.Error() != test.wantErr) {
+			t.Errorf("%s: want error %q, got %v", name, test.wantErr, err)
+		}
+	}
+}
+
+func TestMinor(t *testing.T) {
+	for version, want := range map[string]string{
+		"v0.18.0": "v0.18",
+		"0.17.1":  "v0.17",
+		"v1.0":    "v1.0",
+		"latest":  "latest",
+	} {
+		if got := Minor(version); got != want {
+			t.Errorf("%s: want %s, got %s", version, want, got)
+		}
+	}
+}